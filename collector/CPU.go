@@ -1,40 +1,126 @@
 package collector
 
 import (
+	"context"
 	"github.com/shirou/gopsutil/cpu"
 )
 
-// CPU is the struct that contains data about the CPU
+func init() {
+	Register("cpu", func() Collector { return &CPU{} })
+}
+
+// CPU is the struct that contains data about the CPU. The description of
+// every CPU is collected by the cpu_info collector, and merged in Info.
 type CPU struct {
-	Times        []cpu.TimesStat
-	Info         []cpu.InfoStat
-	Count        int
-	CountLogical int
+	Times        []CPUTimeStat `json:"cpu_time_stat"`
+	Info         []CPUInfoStat `json:"cpu_info_stat"`
+	Count        int           `json:"cpu_count"`
+	CountLogical int           `json:"cpu_count_logical"`
+}
+
+// CPUTimeStat is the time a CPU spent in every state since boot, in seconds
+type CPUTimeStat struct {
+	CPU       string  `json:"cpu"`
+	User      float64 `json:"user"`
+	System    float64 `json:"system"`
+	Idle      float64 `json:"idle"`
+	Nice      float64 `json:"nice"`
+	Iowait    float64 `json:"iowait"`
+	Irq       float64 `json:"irq"`
+	Softirq   float64 `json:"softirq"`
+	Steal     float64 `json:"steal"`
+	Guest     float64 `json:"guest"`
+	GuestNice float64 `json:"guest_nice"`
+	Stolen    float64 `json:"stolen"` // kept for older consumers, always 0
+}
+
+// CPUInfoStat describes a CPU
+type CPUInfoStat struct {
+	CPU        int32    `json:"cpu"`
+	VendorID   string   `json:"vendor_id"`
+	Family     string   `json:"family"`
+	Model      string   `json:"model"`
+	Stepping   int32    `json:"stepping"`
+	PhysicalID string   `json:"physical_id"`
+	CoreID     string   `json:"core_id"`
+	Cores      int32    `json:"cores"`
+	ModelName  string   `json:"model_name"`
+	Mhz        float64  `json:"mhz"`
+	CacheSize  int32    `json:"cache_size"`
+	Flags      []string `json:"flags"`
+}
+
+// Name returns the name of the CPU collector
+func (CPU *CPU) Name() string {
+	return "cpu"
+}
+
+// Configure applies the CPU collector options, it has none
+func (CPU *CPU) Configure(options map[string]interface{}) error {
+	return nil
 }
 
 // Collect helps to collect data about the CPU and store it in the CPU struct
-func (CPU *CPU) Collect() error {
+func (CPU *CPU) Collect(ctx context.Context) error {
 	var err error
 
-	CPU.CountLogical, err = cpu.Counts(true)
+	CPU.CountLogical, err = cpu.CountsWithContext(ctx, true)
 	if err != nil {
 		return err
 	}
 
-	CPU.Count, err = cpu.Counts(false)
+	CPU.Count, err = cpu.CountsWithContext(ctx, false)
 	if err != nil {
 		return err
 	}
 
-	CPU.Times, err = cpu.Times(true)
+	times, err := cpu.TimesWithContext(ctx, true)
 	if err != nil {
 		return err
 	}
 
-	CPU.Info, err = cpu.Info()
-	if err != nil {
-		return err
+	CPU.Times = make([]CPUTimeStat, 0, len(times))
+	for _, t := range times {
+		CPU.Times = append(CPU.Times, CPUTimeStat{
+			CPU:       t.CPU,
+			User:      t.User,
+			System:    t.System,
+			Idle:      t.Idle,
+			Nice:      t.Nice,
+			Iowait:    t.Iowait,
+			Irq:       t.Irq,
+			Softirq:   t.Softirq,
+			Steal:     t.Steal,
+			Guest:     t.Guest,
+			GuestNice: t.GuestNice,
+		})
 	}
 
 	return nil
 }
+
+// Metrics returns the CPU times of every core, in seconds, and the CPU counts
+func (CPU *CPU) Metrics() []Metric {
+	metrics := []Metric{
+		gauge("cpu", "count", nil, float64(CPU.Count)),
+		gauge("cpu", "count_logical", nil, float64(CPU.CountLogical)),
+	}
+
+	for _, times := range CPU.Times {
+		tags := map[string]string{"cpu": times.CPU}
+		metrics = append(metrics,
+			counter("cpu", "user", tags, times.User),
+			counter("cpu", "system", tags, times.System),
+			counter("cpu", "idle", tags, times.Idle),
+			counter("cpu", "nice", tags, times.Nice),
+			counter("cpu", "iowait", tags, times.Iowait),
+			counter("cpu", "irq", tags, times.Irq),
+			counter("cpu", "softirq", tags, times.Softirq),
+			counter("cpu", "steal", tags, times.Steal),
+			counter("cpu", "guest", tags, times.Guest),
+			counter("cpu", "guest_nice", tags, times.GuestNice),
+		)
+	}
+
+	return metrics
+}
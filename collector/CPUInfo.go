@@ -0,0 +1,55 @@
+package collector
+
+import (
+	"context"
+	"github.com/shirou/gopsutil/cpu"
+)
+
+func init() {
+	Register("cpu_info", func() Collector { return &CPUInfo{} })
+}
+
+// CPUInfo is the struct that contains the description of every CPU, which
+// rarely changes, so it is collected apart from the CPU times. It is
+// reported under the cpu segment of the snapshots.
+type CPUInfo struct {
+	Info []CPUInfoStat `json:"cpu_info_stat"`
+}
+
+// Name returns the name of the CPUInfo collector
+func (CPUInfo *CPUInfo) Name() string {
+	return "cpu_info"
+}
+
+// Configure applies the CPUInfo collector options, it has none
+func (CPUInfo *CPUInfo) Configure(options map[string]interface{}) error {
+	return nil
+}
+
+// Collect helps to collect the description of every CPU and store it in the CPUInfo struct
+func (CPUInfo *CPUInfo) Collect(ctx context.Context) error {
+	info, err := cpu.InfoWithContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	CPUInfo.Info = make([]CPUInfoStat, 0, len(info))
+	for _, i := range info {
+		CPUInfo.Info = append(CPUInfo.Info, CPUInfoStat{
+			CPU:        i.CPU,
+			VendorID:   i.VendorID,
+			Family:     i.Family,
+			Model:      i.Model,
+			Stepping:   i.Stepping,
+			PhysicalID: i.PhysicalID,
+			CoreID:     i.CoreID,
+			Cores:      i.Cores,
+			ModelName:  i.ModelName,
+			Mhz:        i.Mhz,
+			CacheSize:  i.CacheSize,
+			Flags:      i.Flags,
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package collector
+
+import (
+	"context"
+	"github.com/shirou/gopsutil/disk"
+)
+
+func init() {
+	Register("disk_partitions", func() Collector { return &DiskPartitions{} })
+}
+
+// DiskPartitions is the struct that contains the mounted partitions, which
+// rarely change, so they are collected apart from the disk usage and IO
+// counters. They are reported under the disks segment of the snapshots.
+type DiskPartitions struct {
+	Partitions []DiskPartitionStat `json:"disk_partition_stat"`
+}
+
+// Name returns the name of the DiskPartitions collector
+func (DiskPartitions *DiskPartitions) Name() string {
+	return "disk_partitions"
+}
+
+// Configure applies the DiskPartitions collector options, it has none
+func (DiskPartitions *DiskPartitions) Configure(options map[string]interface{}) error {
+	return nil
+}
+
+// Collect helps to collect the mounted partitions and store them in the DiskPartitions struct
+func (DiskPartitions *DiskPartitions) Collect(ctx context.Context) error {
+	partitions, err := disk.PartitionsWithContext(ctx, true)
+	if err != nil {
+		return err
+	}
+
+	DiskPartitions.Partitions = make([]DiskPartitionStat, 0, len(partitions))
+	for _, partition := range partitions {
+		DiskPartitions.Partitions = append(DiskPartitions.Partitions, DiskPartitionStat{
+			Device:     partition.Device,
+			Mountpoint: partition.Mountpoint,
+			Fstype:     partition.Fstype,
+			Opts:       partition.Opts,
+		})
+	}
+
+	return nil
+}
@@ -1,36 +1,149 @@
 package collector
 
 import (
+	"context"
 	"github.com/shirou/gopsutil/disk"
+	"sort"
 )
 
-// Disks is the struct that contains data about the Disks
+func init() {
+	Register("disks", func() Collector { return &Disks{} })
+}
+
+// Disks is the struct that contains data about the Disks. The partitions
+// are collected by the disk_partitions collector, and merged in DiskPartition.
 type Disks struct {
-	DiskUsage      interface{}
-	DiskPartition  interface{}
-	DiskIOCounters interface{}
+	DiskUsage      *DiskUsageStat                `json:"disk_usage_stat"`
+	DiskPartition  []DiskPartitionStat           `json:"disk_partition_stat"`
+	DiskIOCounters map[string]DiskIOCountersStat `json:"disk_io_counters_stat"`
 }
 
-// Collect helps to collect data about the Disks and store it in the Disks struct
-func (Disks *Disks) Collect(diskPartition bool) error {
-	var err error
+// DiskUsageStat is the usage of a filesystem, in bytes and inodes
+type DiskUsageStat struct {
+	Path              string  `json:"path"`
+	Total             uint64  `json:"total"`
+	Free              uint64  `json:"free"`
+	Used              uint64  `json:"used"`
+	UsedPercent       float64 `json:"used_percent"`
+	InodesTotal       uint64  `json:"inodes_total"`
+	InodesUsed        uint64  `json:"inodes_used"`
+	InodesFree        uint64  `json:"inodes_free"`
+	InodesUsedPercent float64 `json:"inodes_used_percent"`
+}
+
+// DiskPartitionStat is a mounted partition
+type DiskPartitionStat struct {
+	Device     string `json:"device"`
+	Mountpoint string `json:"mountpoint"`
+	Fstype     string `json:"fstype"`
+	Opts       string `json:"opts"`
+}
+
+// DiskIOCountersStat are the IO counters of a disk since boot, times are in milliseconds
+type DiskIOCountersStat struct {
+	ReadCount      uint64 `json:"read_count"`
+	WriteCount     uint64 `json:"write_count"`
+	ReadBytes      uint64 `json:"read_bytes"`
+	WriteBytes     uint64 `json:"write_bytes"`
+	ReadTime       uint64 `json:"read_time"`
+	WriteTime      uint64 `json:"write_time"`
+	Name           string `json:"name"`
+	IoTime         uint64 `json:"io_time"`
+	IopsInProgress uint64 `json:"iops_in_progress"`
+	SerialNumber   string `json:"serial_number"`
+}
+
+// Name returns the name of the Disks collector
+func (Disks *Disks) Name() string {
+	return "disks"
+}
+
+// Configure applies the Disks collector options, it has none
+func (Disks *Disks) Configure(options map[string]interface{}) error {
+	return nil
+}
 
-	Disks.DiskUsage, err = disk.Usage("/")
+// Collect helps to collect data about the Disks and store it in the Disks struct
+func (Disks *Disks) Collect(ctx context.Context) error {
+	usage, err := disk.UsageWithContext(ctx, "/")
 	if err != nil {
 		return err
 	}
 
-	Disks.DiskIOCounters, err = disk.IOCounters()
+	Disks.DiskUsage = &DiskUsageStat{
+		Path:              usage.Path,
+		Total:             usage.Total,
+		Free:              usage.Free,
+		Used:              usage.Used,
+		UsedPercent:       usage.UsedPercent,
+		InodesTotal:       usage.InodesTotal,
+		InodesUsed:        usage.InodesUsed,
+		InodesFree:        usage.InodesFree,
+		InodesUsedPercent: usage.InodesUsedPercent,
+	}
+
+	counters, err := disk.IOCountersWithContext(ctx)
 	if err != nil {
 		return err
 	}
 
-	if diskPartition {
-		Disks.DiskPartition, err = disk.Partitions(true)
-		if err != nil {
-			return err
+	Disks.DiskIOCounters = make(map[string]DiskIOCountersStat, len(counters))
+	for device, io := range counters {
+		Disks.DiskIOCounters[device] = DiskIOCountersStat{
+			ReadCount:      io.ReadCount,
+			WriteCount:     io.WriteCount,
+			ReadBytes:      io.ReadBytes,
+			WriteBytes:     io.WriteBytes,
+			ReadTime:       io.ReadTime,
+			WriteTime:      io.WriteTime,
+			Name:           io.Name,
+			IoTime:         io.IoTime,
+			IopsInProgress: io.IopsInProgress,
+			SerialNumber:   io.SerialNumber,
 		}
 	}
 
 	return nil
 }
+
+// Metrics returns the usage of the root filesystem and the IO counters of every disk
+func (Disks *Disks) Metrics() []Metric {
+	var metrics []Metric
+
+	if usage := Disks.DiskUsage; usage != nil {
+		tags := map[string]string{"path": usage.Path}
+		metrics = append(metrics,
+			gauge("disk", "total", tags, float64(usage.Total)),
+			gauge("disk", "free", tags, float64(usage.Free)),
+			gauge("disk", "used", tags, float64(usage.Used)),
+			gauge("disk", "used_percent", tags, usage.UsedPercent),
+			gauge("disk", "inodes_total", tags, float64(usage.InodesTotal)),
+			gauge("disk", "inodes_used", tags, float64(usage.InodesUsed)),
+			gauge("disk", "inodes_free", tags, float64(usage.InodesFree)),
+			gauge("disk", "inodes_used_percent", tags, usage.InodesUsedPercent),
+		)
+	}
+
+	devices := make([]string, 0, len(Disks.DiskIOCounters))
+	for device := range Disks.DiskIOCounters {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+
+	for _, device := range devices {
+		io := Disks.DiskIOCounters[device]
+		tags := map[string]string{"device": device}
+		metrics = append(metrics,
+			counter("disk", "read_count", tags, float64(io.ReadCount)),
+			counter("disk", "write_count", tags, float64(io.WriteCount)),
+			counter("disk", "read_bytes", tags, float64(io.ReadBytes)),
+			counter("disk", "write_bytes", tags, float64(io.WriteBytes)),
+			counter("disk", "read_time", tags, float64(io.ReadTime)),
+			counter("disk", "write_time", tags, float64(io.WriteTime)),
+			counter("disk", "io_time", tags, float64(io.IoTime)),
+			gauge("disk", "iops_in_progress", tags, float64(io.IopsInProgress)),
+		)
+	}
+
+	return metrics
+}
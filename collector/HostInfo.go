@@ -0,0 +1,50 @@
+package collector
+
+import (
+	"context"
+	"github.com/shirou/gopsutil/host"
+)
+
+func init() {
+	Register("host_info", func() Collector { return &HostInfo{} })
+}
+
+// HostInfo is the struct that contains the description of the host, which
+// rarely changes, so it is collected apart from the load average. It is
+// reported under the system segment of the snapshots, with the uptime and
+// the number of processes of the system collector.
+type HostInfo struct {
+	Info *HostInfoStat `json:"host_info"`
+}
+
+// Name returns the name of the HostInfo collector
+func (HostInfo *HostInfo) Name() string {
+	return "host_info"
+}
+
+// Configure applies the HostInfo collector options, it has none
+func (HostInfo *HostInfo) Configure(options map[string]interface{}) error {
+	return nil
+}
+
+// Collect helps to collect the description of the host and store it in the HostInfo struct
+func (HostInfo *HostInfo) Collect(ctx context.Context) error {
+	info, err := host.InfoWithContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	HostInfo.Info = &HostInfoStat{
+		Hostname:             info.Hostname,
+		Uptime:               info.Uptime,
+		Procs:                info.Procs,
+		OS:                   info.OS,
+		Platform:             info.Platform,
+		PlatformFamily:       info.PlatformFamily,
+		PlatformVersion:      info.PlatformVersion,
+		VirtualizationSystem: info.VirtualizationSystem,
+		VirtualizationRole:   info.VirtualizationRole,
+	}
+
+	return nil
+}
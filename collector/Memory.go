@@ -1,29 +1,120 @@
 package collector
 
 import (
+	"context"
 	"github.com/shirou/gopsutil/mem"
 )
 
+func init() {
+	Register("memory", func() Collector { return &Memory{} })
+}
+
 // Memory is the struct that contains data about the Memory
 type Memory struct {
-	VirtualMemoryStat interface{}
-	SwapMemoryStat    interface{}
+	VirtualMemoryStat *VirtualMemoryStat `json:"virtual_memory_stat"`
+	SwapMemoryStat    *SwapMemoryStat    `json:"swap_memory_stat"`
+}
+
+// VirtualMemoryStat is the usage of the RAM, in bytes
+type VirtualMemoryStat struct {
+	Total       uint64  `json:"total"`
+	Available   uint64  `json:"available"`
+	Used        uint64  `json:"used"`
+	UsedPercent float64 `json:"used_percent"`
+	Free        uint64  `json:"free"`
+	Active      uint64  `json:"active"`
+	Inactive    uint64  `json:"inactive"`
+	Buffers     uint64  `json:"buffers"`
+	Cached      uint64  `json:"cached"`
+	Wired       uint64  `json:"wired"`
+	Shared      uint64  `json:"shared"`
+}
+
+// SwapMemoryStat is the usage of the swap, in bytes
+type SwapMemoryStat struct {
+	Total       uint64  `json:"total"`
+	Used        uint64  `json:"used"`
+	Free        uint64  `json:"free"`
+	UsedPercent float64 `json:"used_percent"`
+	Sin         uint64  `json:"sin"`
+	Sout        uint64  `json:"sout"`
+}
+
+// Name returns the name of the Memory collector
+func (Memory *Memory) Name() string {
+	return "memory"
+}
+
+// Configure applies the Memory collector options, it has none
+func (Memory *Memory) Configure(options map[string]interface{}) error {
+	return nil
 }
 
 // Collect helps to collect data about the Memory
 // and store it in the Memory struct
-func (Memory *Memory) Collect() error {
-	var err error
-
-	Memory.VirtualMemoryStat, err = mem.VirtualMemory()
+func (Memory *Memory) Collect(ctx context.Context) error {
+	virtual, err := mem.VirtualMemoryWithContext(ctx)
 	if err != nil {
 		return err
 	}
 
-	Memory.SwapMemoryStat, err = mem.SwapMemory()
+	Memory.VirtualMemoryStat = &VirtualMemoryStat{
+		Total:       virtual.Total,
+		Available:   virtual.Available,
+		Used:        virtual.Used,
+		UsedPercent: virtual.UsedPercent,
+		Free:        virtual.Free,
+		Active:      virtual.Active,
+		Inactive:    virtual.Inactive,
+		Buffers:     virtual.Buffers,
+		Cached:      virtual.Cached,
+		Wired:       virtual.Wired,
+		Shared:      virtual.Shared,
+	}
+
+	swap, err := mem.SwapMemoryWithContext(ctx)
 	if err != nil {
 		return err
 	}
 
+	Memory.SwapMemoryStat = &SwapMemoryStat{
+		Total:       swap.Total,
+		Used:        swap.Used,
+		Free:        swap.Free,
+		UsedPercent: swap.UsedPercent,
+		Sin:         swap.Sin,
+		Sout:        swap.Sout,
+	}
+
 	return nil
 }
+
+// Metrics returns the virtual memory and swap usage
+func (Memory *Memory) Metrics() []Metric {
+	var metrics []Metric
+
+	if virtual := Memory.VirtualMemoryStat; virtual != nil {
+		metrics = append(metrics,
+			gauge("mem", "total", nil, float64(virtual.Total)),
+			gauge("mem", "available", nil, float64(virtual.Available)),
+			gauge("mem", "used", nil, float64(virtual.Used)),
+			gauge("mem", "used_percent", nil, virtual.UsedPercent),
+			gauge("mem", "free", nil, float64(virtual.Free)),
+			gauge("mem", "buffers", nil, float64(virtual.Buffers)),
+			gauge("mem", "cached", nil, float64(virtual.Cached)),
+		)
+	}
+
+	if swap := Memory.SwapMemoryStat; swap != nil {
+		metrics = append(metrics,
+			gauge("mem", "swap_total", nil, float64(swap.Total)),
+			gauge("mem", "swap_used", nil, float64(swap.Used)),
+			gauge("mem", "swap_free", nil, float64(swap.Free)),
+			gauge("mem", "swap_used_percent", nil, swap.UsedPercent),
+			counter("mem", "swap_in", nil, float64(swap.Sin)),
+			counter("mem", "swap_out", nil, float64(swap.Sout)),
+		)
+	}
+
+	return metrics
+}
@@ -1,29 +1,118 @@
 package collector
 
 import (
+	"context"
 	"github.com/shirou/gopsutil/net"
 )
 
+func init() {
+	Register("network", func() Collector { return &Network{} })
+}
+
 // Network is the struct that contains data about the Network
 type Network struct {
-	NetIOCounters interface{}
-	NetInterface  interface{}
+	NetIOCounters []NetIOCountersStat `json:"io_counter"`
+	NetInterface  []NetInterfaceStat  `json:"interface"`
+}
+
+// NetIOCountersStat are the IO counters of a network interface since boot
+type NetIOCountersStat struct {
+	Name        string `json:"name"`
+	BytesSent   uint64 `json:"bytes_sent"`
+	BytesRecv   uint64 `json:"bytes_recv"`
+	PacketsSent uint64 `json:"packets_sent"`
+	PacketsRecv uint64 `json:"packets_recv"`
+	Errin       uint64 `json:"errin"`
+	Errout      uint64 `json:"errout"`
+	Dropin      uint64 `json:"dropin"`
+	Dropout     uint64 `json:"dropout"`
+}
+
+// NetInterfaceStat describes a network interface
+type NetInterfaceStat struct {
+	MTU          int      `json:"mtu"`
+	Name         string   `json:"name"`
+	HardwareAddr string   `json:"hardwareaddr"`
+	Flags        []string `json:"flags"`
+
+	// Addrs are the addresses of the interface, in CIDR notation
+	Addrs []string `json:"addrs"`
+}
+
+// Name returns the name of the Network collector
+func (Network *Network) Name() string {
+	return "network"
+}
+
+// Configure applies the Network collector options, it has none
+func (Network *Network) Configure(options map[string]interface{}) error {
+	return nil
 }
 
 // Collect helps to collect data about the Network
 // and store it in the Network struct
-func (Network *Network) Collect() error {
-	var err error
-
-	Network.NetIOCounters, err = net.IOCounters(true)
+func (Network *Network) Collect(ctx context.Context) error {
+	counters, err := net.IOCountersWithContext(ctx, true)
 	if err != nil {
 		return err
 	}
 
-	Network.NetInterface, err = net.Interfaces()
+	Network.NetIOCounters = make([]NetIOCountersStat, 0, len(counters))
+	for _, io := range counters {
+		Network.NetIOCounters = append(Network.NetIOCounters, NetIOCountersStat{
+			Name:        io.Name,
+			BytesSent:   io.BytesSent,
+			BytesRecv:   io.BytesRecv,
+			PacketsSent: io.PacketsSent,
+			PacketsRecv: io.PacketsRecv,
+			Errin:       io.Errin,
+			Errout:      io.Errout,
+			Dropin:      io.Dropin,
+			Dropout:     io.Dropout,
+		})
+	}
+
+	interfaces, err := net.InterfacesWithContext(ctx)
 	if err != nil {
 		return err
 	}
 
+	Network.NetInterface = make([]NetInterfaceStat, 0, len(interfaces))
+	for _, i := range interfaces {
+		addrs := make([]string, 0, len(i.Addrs))
+		for _, addr := range i.Addrs {
+			addrs = append(addrs, addr.Addr)
+		}
+
+		Network.NetInterface = append(Network.NetInterface, NetInterfaceStat{
+			MTU:          i.MTU,
+			Name:         i.Name,
+			HardwareAddr: i.HardwareAddr,
+			Flags:        i.Flags,
+			Addrs:        addrs,
+		})
+	}
+
 	return nil
 }
+
+// Metrics returns the IO counters of every network interface
+func (Network *Network) Metrics() []Metric {
+	var metrics []Metric
+
+	for _, io := range Network.NetIOCounters {
+		tags := map[string]string{"interface": io.Name}
+		metrics = append(metrics,
+			counter("net", "bytes_sent", tags, float64(io.BytesSent)),
+			counter("net", "bytes_recv", tags, float64(io.BytesRecv)),
+			counter("net", "packets_sent", tags, float64(io.PacketsSent)),
+			counter("net", "packets_recv", tags, float64(io.PacketsRecv)),
+			counter("net", "err_in", tags, float64(io.Errin)),
+			counter("net", "err_out", tags, float64(io.Errout)),
+			counter("net", "drop_in", tags, float64(io.Dropin)),
+			counter("net", "drop_out", tags, float64(io.Dropout)),
+		)
+	}
+
+	return metrics
+}
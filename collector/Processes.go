@@ -0,0 +1,334 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"github.com/shirou/gopsutil/process"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("processes", func() Collector { return &Processes{} })
+}
+
+// The defaults of the Processes collector options
+const (
+	defaultTopProcesses     = 5
+	defaultCmdlineMaxLength = 256
+)
+
+// defaultRedactions hide the values of the command line arguments which look like credentials
+var defaultRedactions = []string{`(?i)(?:password|passwd|secret|token|api[_-]?key)[=: ]\s*(\S+)`}
+
+// redacted replaces the redacted parts of a command line
+const redacted = "[redacted]"
+
+// Processes is the struct that contains data about the running processes
+type Processes struct {
+	Total     int            `json:"total"`
+	States    map[string]int `json:"states"`
+	TopCPU    []ProcessStat  `json:"top_cpu"`
+	TopMemory []ProcessStat  `json:"top_memory"`
+
+	top              int
+	cmdlineMaxLength int
+	redactions       []*regexp.Regexp
+}
+
+// ProcessStat describes a process. Its CPU percent is of a single CPU, over
+// the time since the previous collection or, for the processes started
+// since, over the lifetime of the process. Its create time is a Unix time
+// in milliseconds, and its open FDs are -1 when they cannot be counted,
+// such as for the processes of other users without privileges.
+type ProcessStat struct {
+	PID        int32   `json:"pid"`
+	Name       string  `json:"name"`
+	User       string  `json:"user"`
+	Cmdline    string  `json:"cmdline"`
+	CPUPercent float64 `json:"cpu_percent"`
+	RSS        uint64  `json:"rss"`
+	Threads    int32   `json:"threads"`
+	OpenFDs    int32   `json:"open_fds"`
+	CreateTime int64   `json:"create_time"`
+}
+
+// cpuSample is the CPU time a process had used at a collection
+type cpuSample struct {
+	createTime int64
+	seconds    float64
+}
+
+var (
+	// cpuSamples holds the CPU time of every process at the previous
+	// collection, when cpuSampledAt, since every run has its own collector
+	cpuSamples      map[int32]cpuSample
+	cpuSampledAt    time.Time
+	cpuSamplesMutex sync.Mutex
+)
+
+// Name returns the name of the Processes collector
+func (Processes *Processes) Name() string {
+	return "processes"
+}
+
+// Configure applies the Processes collector options: top is how many
+// processes are listed by CPU and by memory, cmdline_max_length is the
+// length command lines are truncated to, 0 keeping them whole, and redact
+// lists the regular expressions whose matches are hidden from command
+// lines, only their groups when they have some.
+func (Processes *Processes) Configure(options map[string]interface{}) error {
+	var err error
+	if Processes.top, err = IntOption(options, "top", defaultTopProcesses); err != nil {
+		return err
+	}
+	if Processes.cmdlineMaxLength, err = IntOption(options, "cmdline_max_length", defaultCmdlineMaxLength); err != nil {
+		return err
+	}
+	if Processes.top < 0 || Processes.cmdlineMaxLength < 0 {
+		return fmt.Errorf("options \"top\" and \"cmdline_max_length\" must not be negative")
+	}
+
+	patterns, err := StringsOption(options, "redact", defaultRedactions)
+	if err != nil {
+		return err
+	}
+
+	Processes.redactions = make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		redaction, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("option \"redact\": %s", err)
+		}
+		Processes.redactions = append(Processes.redactions, redaction)
+	}
+	return nil
+}
+
+// Collect helps to collect data about the processes and store it in the Processes struct
+func (Processes *Processes) Collect(ctx context.Context) error {
+	procs, err := process.ProcessesWithContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	cpuSamplesMutex.Lock()
+	previous, previousAt := cpuSamples, cpuSampledAt
+	cpuSamplesMutex.Unlock()
+
+	samples := make(map[int32]cpuSample, len(procs))
+	stats := make([]ProcessStat, 0, len(procs))
+	handles := make(map[int32]*process.Process, len(procs))
+	Processes.States = map[string]int{"running": 0, "sleeping": 0, "zombie": 0}
+
+	for _, p := range procs {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		// the processes which exited since they were listed are left out
+		status, err := p.StatusWithContext(ctx)
+		if err != nil {
+			continue
+		}
+		Processes.Total++
+		Processes.States[processState(status)]++
+
+		stat := ProcessStat{PID: p.Pid, OpenFDs: -1}
+		if createTime, err := p.CreateTimeWithContext(ctx); err == nil {
+			stat.CreateTime = createTime
+		}
+		if times, err := p.TimesWithContext(ctx); err == nil {
+			sample := cpuSample{createTime: stat.CreateTime, seconds: times.User + times.System}
+			samples[p.Pid] = sample
+			last, known := previous[p.Pid]
+			stat.CPUPercent = cpuPercent(sample, last, known, now.Sub(previousAt), now)
+		}
+		if memory, err := p.MemoryInfoWithContext(ctx); err == nil {
+			stat.RSS = memory.RSS
+		}
+
+		stats = append(stats, stat)
+		handles[p.Pid] = p
+	}
+
+	cpuSamplesMutex.Lock()
+	cpuSamples, cpuSampledAt = samples, now
+	cpuSamplesMutex.Unlock()
+
+	Processes.TopCPU = topProcesses(stats, Processes.top, func(a, b ProcessStat) bool { return a.CPUPercent > b.CPUPercent })
+	Processes.TopMemory = topProcesses(stats, Processes.top, func(a, b ProcessStat) bool { return a.RSS > b.RSS })
+
+	// the details are only read for the listed processes
+	for _, top := range [][]ProcessStat{Processes.TopCPU, Processes.TopMemory} {
+		for index := range top {
+			Processes.describe(ctx, handles[top[index].PID], &top[index])
+		}
+	}
+
+	return nil
+}
+
+// describe fills the name, user, command line, threads and open FDs of a process
+func (Processes *Processes) describe(ctx context.Context, p *process.Process, stat *ProcessStat) {
+	stat.Name, _ = p.NameWithContext(ctx)
+	stat.User, _ = p.UsernameWithContext(ctx)
+	stat.Threads, _ = p.NumThreadsWithContext(ctx)
+	if fds, err := p.NumFDsWithContext(ctx); err == nil {
+		stat.OpenFDs = fds
+	}
+	if cmdline, err := p.CmdlineWithContext(ctx); err == nil {
+		stat.Cmdline = Processes.cleanCmdline(cmdline)
+	}
+}
+
+// cleanCmdline returns a command line redacted, then truncated
+func (Processes *Processes) cleanCmdline(cmdline string) string {
+	for _, redaction := range Processes.redactions {
+		cmdline = redact(redaction, cmdline)
+	}
+
+	if runes := []rune(cmdline); Processes.cmdlineMaxLength > 0 && len(runes) > Processes.cmdlineMaxLength {
+		cmdline = string(runes[:Processes.cmdlineMaxLength]) + "..."
+	}
+	return cmdline
+}
+
+// redact hides the matches of redaction in s, only their groups when redaction has some
+func redact(redaction *regexp.Regexp, s string) string {
+	var result []byte
+	last := 0
+	for _, match := range redaction.FindAllStringSubmatchIndex(s, -1) {
+		spans := match[:2]
+		if len(match) > 2 {
+			spans = match[2:]
+		}
+
+		for i := 0; i < len(spans); i += 2 {
+			// groups which did not take part in the match are -1, nested ones are already hidden
+			if spans[i] < last {
+				continue
+			}
+			result = append(result, s[last:spans[i]]...)
+			result = append(result, redacted...)
+			last = spans[i+1]
+		}
+	}
+	return string(append(result, s[last:]...))
+}
+
+// cpuPercent returns the percent of a CPU a process used since its previous
+// sample, elapsed ago, or over its lifetime when it has no previous sample,
+// which is the case when its PID is new or was reused by another process
+func cpuPercent(sample cpuSample, previous cpuSample, known bool, elapsed time.Duration, now time.Time) float64 {
+	seconds := sample.seconds
+	if known && previous.createTime == sample.createTime && previous.seconds <= sample.seconds && elapsed > 0 {
+		seconds -= previous.seconds
+	} else {
+		elapsed = now.Sub(time.Unix(0, sample.createTime*int64(time.Millisecond)))
+	}
+
+	if elapsed <= 0 {
+		return 0
+	}
+	return seconds / elapsed.Seconds() * 100
+}
+
+// processState returns the state of a process status, as reported by gopsutil
+func processState(status string) string {
+	switch status {
+	case "R":
+		return "running"
+	case "S":
+		return "sleeping"
+	case "D":
+		return "disk_sleep"
+	case "Z":
+		return "zombie"
+	case "T", "t":
+		return "stopped"
+	case "I":
+		return "idle"
+	case "W":
+		return "waiting"
+	case "L":
+		return "locked"
+	}
+	return "other"
+}
+
+// topProcesses returns the top processes of stats as ranked by before, the lowest PIDs first among equals
+func topProcesses(stats []ProcessStat, top int, before func(a, b ProcessStat) bool) []ProcessStat {
+	sorted := append([]ProcessStat(nil), stats...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if before(sorted[i], sorted[j]) != before(sorted[j], sorted[i]) {
+			return before(sorted[i], sorted[j])
+		}
+		return sorted[i].PID < sorted[j].PID
+	})
+
+	if len(sorted) > top {
+		sorted = sorted[:top]
+	}
+	return sorted
+}
+
+// Metrics returns the number of processes, in total and by state, and the usage of the top processes
+func (Processes *Processes) Metrics() []Metric {
+	metrics := []Metric{gauge("processes", "total", nil, float64(Processes.Total))}
+
+	states := make([]string, 0, len(Processes.States))
+	for state := range Processes.States {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+	for _, state := range states {
+		metrics = append(metrics, gauge("processes", "count", map[string]string{"state": state}, float64(Processes.States[state])))
+	}
+
+	// the top processes are summed by name, rather than tagged with their
+	// PID, which would make a new series of every process
+	var names []string
+	usages := make(map[string]*ProcessStat)
+	seen := make(map[int32]bool)
+	for _, stat := range append(append([]ProcessStat(nil), Processes.TopCPU...), Processes.TopMemory...) {
+		if seen[stat.PID] {
+			continue
+		}
+		seen[stat.PID] = true
+
+		usage, ok := usages[stat.Name]
+		if !ok {
+			usage = &ProcessStat{Name: stat.Name}
+			usages[stat.Name] = usage
+			names = append(names, stat.Name)
+		}
+		usage.CPUPercent += stat.CPUPercent
+		usage.RSS += stat.RSS
+		usage.Threads += stat.Threads
+		// the open files are only known when they are of every process
+		if stat.OpenFDs < 0 || usage.OpenFDs < 0 {
+			usage.OpenFDs = -1
+		} else {
+			usage.OpenFDs += stat.OpenFDs
+		}
+	}
+
+	for _, name := range names {
+		usage := usages[name]
+		tags := map[string]string{"name": name}
+		metrics = append(metrics,
+			gauge("process", "cpu_percent", tags, usage.CPUPercent),
+			gauge("process", "rss", tags, float64(usage.RSS)),
+			gauge("process", "threads", tags, float64(usage.Threads)),
+		)
+		if usage.OpenFDs >= 0 {
+			metrics = append(metrics, gauge("process", "open_fds", tags, float64(usage.OpenFDs)))
+		}
+	}
+
+	return metrics
+}
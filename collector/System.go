@@ -1,37 +1,165 @@
 package collector
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/shirou/gopsutil/host"
 	"github.com/shirou/gopsutil/load"
+	"github.com/shirou/gopsutil/process"
+	"strconv"
 )
 
-// System is the struct that contains data about the System
+func init() {
+	Register("system", func() Collector { return &System{} })
+}
+
+// System is the struct that contains data about the System. It only
+// collects the uptime and the number of processes of the host information,
+// the rest is collected by the host_info collector and merged in HostInfo.
 type System struct {
-	HostInfo interface{}
-	LoadAvg  interface{}
-	Users    interface{}
+	HostInfo *HostInfoStat `json:"host_info"`
+	LoadAvg  *LoadAvgStat  `json:"load_avg"`
+	Users    []UserStat    `json:"users"`
+
+	includeUsers bool
 }
 
-// Collect helps to collect data about the System and store it in the System struct
-func (SystemPtr *System) Collect(users bool) error {
+// HostInfoStat describes the host, its uptime is in seconds
+type HostInfoStat struct {
+	Hostname             string `json:"hostname"`
+	Uptime               uint64 `json:"uptime"`
+	Procs                uint64 `json:"procs"`
+	OS                   string `json:"os"`
+	Platform             string `json:"platform"`
+	PlatformFamily       string `json:"platform_family"`
+	PlatformVersion      string `json:"platform_version"`
+	VirtualizationSystem string `json:"virtualization_system"`
+	VirtualizationRole   string `json:"virtualization_role"`
+}
+
+// LoadAvgStat is the load average over 1, 5 and 15 minutes. Its JSON is
+// the documented array of the three values as strings, such as ["0","0.01","0.05"].
+type LoadAvgStat struct {
+	Load1  float64
+	Load5  float64
+	Load15 float64
+}
+
+// MarshalJSON returns the load averages as an array of strings
+func (avg LoadAvgStat) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]string{
+		strconv.FormatFloat(avg.Load1, 'f', -1, 64),
+		strconv.FormatFloat(avg.Load5, 'f', -1, 64),
+		strconv.FormatFloat(avg.Load15, 'f', -1, 64),
+	})
+}
+
+// UnmarshalJSON reads the load averages back from an array of strings
+func (avg *LoadAvgStat) UnmarshalJSON(data []byte) error {
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	if len(values) != 3 {
+		return fmt.Errorf("load_avg must hold 3 values, got %d", len(values))
+	}
+
+	for index, field := range []*float64{&avg.Load1, &avg.Load5, &avg.Load15} {
+		value, err := strconv.ParseFloat(values[index], 64)
+		if err != nil {
+			return fmt.Errorf("load_avg: %s", err)
+		}
+		*field = value
+	}
+	return nil
+}
+
+// UserStat is a logged in user, started is a Unix time
+type UserStat struct {
+	User     string `json:"user"`
+	Terminal string `json:"terminal"`
+	Host     string `json:"host"`
+	Started  int    `json:"started"`
+}
+
+// Name returns the name of the System collector
+func (SystemPtr *System) Name() string {
+	return "system"
+}
+
+// Configure applies the System collector options:
+// include_users also collects the logged in users
+func (SystemPtr *System) Configure(options map[string]interface{}) error {
 	var err error
+	SystemPtr.includeUsers, err = BoolOption(options, "include_users", false)
+	return err
+}
 
-	SystemPtr.HostInfo, err = host.Info()
+// Collect helps to collect data about the System and store it in the System struct
+func (SystemPtr *System) Collect(ctx context.Context) error {
+	uptime, err := host.UptimeWithContext(ctx)
 	if err != nil {
 		return err
 	}
 
-	SystemPtr.LoadAvg, err = load.Avg()
+	pids, err := process.PidsWithContext(ctx)
 	if err != nil {
 		return err
 	}
 
-	if users {
-		SystemPtr.Users, err = host.Users()
+	// the rest of the host information is collected by the host_info collector
+	SystemPtr.HostInfo = &HostInfoStat{Uptime: uptime, Procs: uint64(len(pids))}
+
+	avg, err := load.AvgWithContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	SystemPtr.LoadAvg = &LoadAvgStat{Load1: avg.Load1, Load5: avg.Load5, Load15: avg.Load15}
+
+	if SystemPtr.includeUsers {
+		users, err := host.UsersWithContext(ctx)
 		if err != nil {
 			return err
 		}
+
+		SystemPtr.Users = make([]UserStat, 0, len(users))
+		for _, user := range users {
+			SystemPtr.Users = append(SystemPtr.Users, UserStat{
+				User:     user.User,
+				Terminal: user.Terminal,
+				Host:     user.Host,
+				Started:  user.Started,
+			})
+		}
 	}
 
 	return nil
 }
+
+// Metrics returns the load average, uptime and, when collected, the number of users
+func (SystemPtr *System) Metrics() []Metric {
+	var metrics []Metric
+
+	if avg := SystemPtr.LoadAvg; avg != nil {
+		metrics = append(metrics,
+			gauge("system", "load1", nil, avg.Load1),
+			gauge("system", "load5", nil, avg.Load5),
+			gauge("system", "load15", nil, avg.Load15),
+		)
+	}
+
+	if info := SystemPtr.HostInfo; info != nil {
+		metrics = append(metrics,
+			gauge("system", "uptime", nil, float64(info.Uptime)),
+			gauge("system", "procs", nil, float64(info.Procs)),
+		)
+	}
+
+	if SystemPtr.Users != nil {
+		metrics = append(metrics, gauge("system", "users", nil, float64(len(SystemPtr.Users))))
+	}
+
+	return metrics
+}
@@ -0,0 +1,325 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"github.com/shirou/gopsutil/process"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("watch", func() Collector { return &Watch{} })
+}
+
+// Watch is the struct that contains the state of the watched processes
+type Watch struct {
+	Processes []WatchedProcessStat `json:"processes"`
+
+	watched []watchedProcess
+}
+
+// WatchedProcessStat is the state of a watched process. It is up when
+// at least one instance runs, zombies aside. A restart is detected when
+// one of its PIDs is gone and another one appeared since the previous
+// collection it was up at, restarts counts them since the emitter started.
+// Its uptime is the one of its oldest instance, in seconds.
+type WatchedProcessStat struct {
+	Name      string  `json:"name"`
+	Up        bool    `json:"up"`
+	Instances int     `json:"instances"`
+	PIDs      []int32 `json:"pids"`
+	Restarted bool    `json:"restarted"`
+	Restarts  uint64  `json:"restarts"`
+	Uptime    uint64  `json:"uptime"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// watchedProcess tells how the processes of a watched process are found,
+// by one of their name, a regular expression on their command line, or a pidfile
+type watchedProcess struct {
+	name        string
+	processName string
+	cmdline     *regexp.Regexp
+	pidFile     string
+}
+
+// watchState is what is remembered of a watched process between collections
+type watchState struct {
+	// pids are the PIDs of the last collection the process was up at
+	pids     []int32
+	restarts uint64
+}
+
+// pidFileSlack is how long a process may seem to have started after its
+// pidfile was written, as its create time is only known to the second
+const pidFileSlack = 2 * time.Second
+
+var (
+	// watchStates holds the state of every watched process by name,
+	// since every run has its own collector
+	watchStates      = make(map[string]watchState)
+	watchStatesMutex sync.Mutex
+)
+
+// Name returns the name of the Watch collector
+func (Watch *Watch) Name() string {
+	return "watch"
+}
+
+// Configure applies the Watch collector options: processes lists the
+// watched processes, each with its name and one of process_name, cmdline
+// and pidfile. They are taken from settings.watch by default.
+func (Watch *Watch) Configure(options map[string]interface{}) error {
+	value, exists := options["processes"]
+	if !exists {
+		return nil
+	}
+
+	list, ok := value.([]interface{})
+	if !ok {
+		return fmt.Errorf("option \"processes\" must be a list of objects")
+	}
+
+	Watch.watched = make([]watchedProcess, 0, len(list))
+	for index, item := range list {
+		fields, ok := item.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("option \"processes\" must be a list of objects")
+		}
+
+		var watched watchedProcess
+		var cmdline string
+		for key, field := range map[string]*string{"name": &watched.name, "process_name": &watched.processName, "cmdline": &cmdline, "pidfile": &watched.pidFile} {
+			if fields[key] == nil {
+				continue
+			}
+			if *field, ok = fields[key].(string); !ok {
+				return fmt.Errorf("option \"processes\": %s of entry %d must be a string", key, index)
+			}
+		}
+
+		if cmdline != "" {
+			var err error
+			if watched.cmdline, err = regexp.Compile(cmdline); err != nil {
+				return fmt.Errorf("option \"processes\": cmdline of %s: %s", watched.name, err)
+			}
+		}
+		Watch.watched = append(Watch.watched, watched)
+	}
+	return nil
+}
+
+// Collect helps to collect the state of the watched processes and store it in the Watch struct
+func (Watch *Watch) Collect(ctx context.Context) error {
+	pids, errs, err := Watch.find(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	Watch.Processes = make([]WatchedProcessStat, 0, len(Watch.watched))
+	for index, watched := range Watch.watched {
+		stat := WatchedProcessStat{Name: watched.name, PIDs: make([]int32, 0, len(pids[index]))}
+		if errs[index] != nil {
+			stat.Error = errs[index].Error()
+		}
+
+		var oldest int64
+		for pid, createTime := range pids[index] {
+			stat.PIDs = append(stat.PIDs, pid)
+			if oldest == 0 || (createTime > 0 && createTime < oldest) {
+				oldest = createTime
+			}
+		}
+		sort.Slice(stat.PIDs, func(i, j int) bool { return stat.PIDs[i] < stat.PIDs[j] })
+
+		stat.Instances = len(stat.PIDs)
+		stat.Up = stat.Instances > 0
+		if stat.Up && oldest > 0 {
+			if uptime := now.Sub(time.Unix(0, oldest*int64(time.Millisecond))); uptime > 0 {
+				stat.Uptime = uint64(uptime / time.Second)
+			}
+		}
+		stat.Restarted, stat.Restarts = observe(watched.name, stat.PIDs)
+
+		Watch.Processes = append(Watch.Processes, stat)
+	}
+
+	return nil
+}
+
+// find returns the create time of the running processes of every watched
+// process by PID, and why a pidfile could not be read. Processes are only
+// listed when a watched process is found by name or command line.
+func (Watch *Watch) find(ctx context.Context) ([]map[int32]int64, []error, error) {
+	pids := make([]map[int32]int64, len(Watch.watched))
+	errs := make([]error, len(Watch.watched))
+
+	listing := false
+	for index, watched := range Watch.watched {
+		pids[index] = make(map[int32]int64)
+		if watched.pidFile == "" {
+			listing = true
+			continue
+		}
+
+		p, written, err := readPIDFile(watched.pidFile)
+		if err != nil {
+			errs[index] = err
+			continue
+		}
+		createTime, alive := aliveSince(ctx, p)
+		if !alive {
+			continue
+		}
+		// a process started after the pidfile was written is not the one
+		// it was written for: the PID was reused after a crash
+		if createTime > written.Add(pidFileSlack).UnixNano()/int64(time.Millisecond) {
+			errs[index] = fmt.Errorf("%s is stale, PID %d was reused", watched.pidFile, p.Pid)
+			continue
+		}
+		pids[index][p.Pid] = createTime
+	}
+
+	if !listing {
+		return pids, errs, nil
+	}
+
+	procs, err := process.ProcessesWithContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, p := range procs {
+		if err = ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		// the name and command line are only read once needed
+		var name, cmdline *string
+		for index, watched := range Watch.watched {
+			switch {
+			case watched.processName != "":
+				if name == nil {
+					s, _ := p.NameWithContext(ctx)
+					name = &s
+				}
+				if *name != watched.processName {
+					continue
+				}
+			case watched.cmdline != nil:
+				if cmdline == nil {
+					s, _ := p.CmdlineWithContext(ctx)
+					cmdline = &s
+				}
+				if !watched.cmdline.MatchString(*cmdline) {
+					continue
+				}
+			default:
+				continue
+			}
+
+			if createTime, alive := aliveSince(ctx, p); alive {
+				pids[index][p.Pid] = createTime
+			}
+		}
+	}
+
+	return pids, errs, nil
+}
+
+// readPIDFile returns the process whose PID is written in a pidfile,
+// and when the pidfile was written
+func readPIDFile(pidFile string) (*process.Process, time.Time, error) {
+	content, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	info, err := os.Stat(pidFile)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 32)
+	if err != nil || pid <= 0 {
+		return nil, time.Time{}, fmt.Errorf("%s does not hold a PID", pidFile)
+	}
+	return &process.Process{Pid: int32(pid)}, info.ModTime(), nil
+}
+
+// aliveSince returns the create time of a process, in milliseconds, and whether it runs and is not a zombie
+func aliveSince(ctx context.Context, p *process.Process) (int64, bool) {
+	status, err := p.StatusWithContext(ctx)
+	if err != nil || status == "Z" {
+		return 0, false
+	}
+
+	createTime, _ := p.CreateTimeWithContext(ctx)
+	return createTime, true
+}
+
+// observe records the PIDs a watched process runs under, and returns
+// whether it restarted since the previous collection it was up at, and
+// how many times it restarted so far
+func observe(name string, pids []int32) (bool, uint64) {
+	watchStatesMutex.Lock()
+	defer watchStatesMutex.Unlock()
+
+	state := watchStates[name]
+	restarted := replaced(state.pids, pids)
+	if restarted {
+		state.restarts++
+	}
+	if len(pids) > 0 {
+		state.pids = pids
+	}
+	watchStates[name] = state
+
+	return restarted, state.restarts
+}
+
+// replaced tells whether one of the previous PIDs is gone and another one appeared
+func replaced(previous []int32, current []int32) bool {
+	known := make(map[int32]bool, len(previous))
+	for _, pid := range previous {
+		known[pid] = true
+	}
+
+	appeared := false
+	for _, pid := range current {
+		if !known[pid] {
+			appeared = true
+		}
+		delete(known, pid)
+	}
+	return appeared && len(known) > 0
+}
+
+// Metrics returns whether every watched process is up, its instances, uptime and restarts
+func (Watch *Watch) Metrics() []Metric {
+	var metrics []Metric
+
+	for _, stat := range Watch.Processes {
+		tags := map[string]string{"name": stat.Name}
+		up := 0.0
+		if stat.Up {
+			up = 1
+		}
+
+		metrics = append(metrics,
+			gauge("watch", "up", tags, up),
+			gauge("watch", "instances", tags, float64(stat.Instances)),
+			gauge("watch", "uptime", tags, float64(stat.Uptime)),
+			counter("watch", "restarts", tags, float64(stat.Restarts)),
+		)
+	}
+
+	return metrics
+}
@@ -0,0 +1,142 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Collector is implemented by every segment of a snapshot. A Collector
+// gathers its data in Collect and keeps it in its own fields, which are
+// what ends up in the report.
+type Collector interface {
+	// Name returns the name the collector is registered and configured under
+	Name() string
+
+	// Configure applies the options given to the collector in the configuration
+	Configure(options map[string]interface{}) error
+
+	// Collect gathers the data about the system and stores it in the collector
+	Collect(ctx context.Context) error
+}
+
+// Factory returns a new, unconfigured, Collector
+type Factory func() Collector
+
+var (
+	registryMutex sync.RWMutex
+	registry      = make(map[string]Factory)
+)
+
+// Register makes a collector available under the given name.
+// It panics if the name is registered twice.
+func Register(name string, factory Factory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if _, exists := registry[name]; exists {
+		panic("collector: Register called twice for " + name)
+	}
+	registry[name] = factory
+}
+
+// New returns a new instance of the collector registered under name
+func New(name string) (Collector, error) {
+	registryMutex.RLock()
+	factory, exists := registry[name]
+	registryMutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("unknown collector %q", name)
+	}
+	return factory(), nil
+}
+
+// Names returns the sorted names of all registered collectors
+func Names() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The options of collectors, and of sinks alike, are read with BoolOption,
+// IntOption, StringOption and StringsOption, which return an error
+// naming the option when it has the wrong type.
+
+// BoolOption returns the boolean option under key, or fallback if it is not set
+func BoolOption(options map[string]interface{}, key string, fallback bool) (bool, error) {
+	value, exists := options[key]
+	if !exists {
+		return fallback, nil
+	}
+
+	b, ok := value.(bool)
+	if !ok {
+		return fallback, fmt.Errorf("option %q must be a boolean", key)
+	}
+	return b, nil
+}
+
+// IntOption returns the integer option under key, or fallback if it is not set.
+// Options read from JSON are numbers, which must not have a fractional part.
+func IntOption(options map[string]interface{}, key string, fallback int) (int, error) {
+	value, exists := options[key]
+	if !exists {
+		return fallback, nil
+	}
+
+	switch n := value.(type) {
+	case int:
+		return n, nil
+	case float64:
+		if n == float64(int(n)) {
+			return int(n), nil
+		}
+	}
+	return fallback, fmt.Errorf("option %q must be an integer", key)
+}
+
+// StringOption returns the string option under key, or fallback if it is not set
+func StringOption(options map[string]interface{}, key string, fallback string) (string, error) {
+	value, exists := options[key]
+	if !exists {
+		return fallback, nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return fallback, fmt.Errorf("option %q must be a string", key)
+	}
+	return s, nil
+}
+
+// StringsOption returns the list of strings under key, or fallback if it is not set
+func StringsOption(options map[string]interface{}, key string, fallback []string) ([]string, error) {
+	value, exists := options[key]
+	if !exists {
+		return fallback, nil
+	}
+
+	switch list := value.(type) {
+	case []string:
+		return list, nil
+	case []interface{}:
+		strs := make([]string, 0, len(list))
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				return fallback, fmt.Errorf("option %q must be a list of strings", key)
+			}
+			strs = append(strs, s)
+		}
+		return strs, nil
+	}
+	return fallback, fmt.Errorf("option %q must be a list of strings", key)
+}
@@ -0,0 +1,238 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestNames(t *testing.T) {
+	expected := "[cpu cpu_info disk_partitions disks host_info memory network processes system watch]"
+	if actual := fmt.Sprint(Names()); actual != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, actual)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		invalid bool
+	}{
+		{"cpu", false},
+		{"cpu_info", false},
+		{"disk_partitions", false},
+		{"disks", false},
+		{"host_info", false},
+		{"memory", false},
+		{"network", false},
+		{"processes", false},
+		{"system", false},
+		{"watch", false},
+		{"unknown", true},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			c, err := New(test.name)
+			if test.invalid {
+				if err == nil {
+					t.Fatalf("expected an error for '%s'", test.name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Name() != test.name {
+				t.Fatalf("expected '%s', got '%s'", test.name, c.Name())
+			}
+		})
+	}
+}
+
+func TestProcesses_CleanCmdline(t *testing.T) {
+	tests := []struct {
+		options  map[string]interface{}
+		cmdline  string
+		expected string
+	}{
+		{nil, "nginx -g daemon off;", "nginx -g daemon off;"},
+		{nil, "mysqld --user=mysql --password=hunter2 --port 3306", "mysqld --user=mysql --password=[redacted] --port 3306"},
+		{nil, "worker --api-key abc123 --TOKEN=xyz", "worker --api-key [redacted] --TOKEN=[redacted]"},
+		{map[string]interface{}{"cmdline_max_length": 10.0}, "/usr/bin/python3 app.py", "/usr/bin/p..."},
+		{map[string]interface{}{"redact": []interface{}{`\d+\.\d+\.\d+\.\d+`}}, "ssh 10.0.0.1 --password=x", "ssh [redacted] --password=x"},
+		{map[string]interface{}{"redact": []interface{}{`-u (\S+) -p (\S+)`}}, "mysql -u root -p secret", "mysql -u [redacted] -p [redacted]"},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			var processes Processes
+			if err := processes.Configure(test.options); err != nil {
+				t.Fatal(err)
+			}
+			if actual := processes.cleanCmdline(test.cmdline); actual != test.expected {
+				t.Fatalf("expected '%s', got '%s'", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCPUPercent(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	started := now.Add(-100*time.Second).UnixNano() / int64(time.Millisecond)
+
+	tests := []struct {
+		previous cpuSample
+		known    bool
+		expected string
+	}{
+		{cpuSample{started, 40}, true, "50"},     // 5 seconds of CPU over the last 10
+		{cpuSample{}, false, "45"},               // 45 seconds of CPU over its lifetime
+		{cpuSample{started - 1, 40}, true, "45"}, // the PID was reused
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			actual := fmt.Sprint(cpuPercent(cpuSample{started, 45}, test.previous, test.known, 10*time.Second, now))
+			if actual != test.expected {
+				t.Fatalf("expected '%s', got '%s'", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestWatch_Collect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sse-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	pidFile := filepath.Join(dir, "test.pid")
+	if err = ioutil.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the PID of this test written before it started, as if reused
+	stalePIDFile := filepath.Join(dir, "stale.pid")
+	if err = ioutil.WriteFile(stalePIDFile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644); err != nil {
+		t.Fatal(err)
+	}
+	written := time.Now().Add(-time.Hour)
+	if err = os.Chtimes(stalePIDFile, written, written); err != nil {
+		t.Fatal(err)
+	}
+
+	var watch Watch
+	err = watch.Configure(map[string]interface{}{"processes": []interface{}{
+		map[string]interface{}{"name": "pidfile", "pidfile": pidFile},
+		map[string]interface{}{"name": "cmdline", "cmdline": regexp.QuoteMeta(os.Args[0])},
+		map[string]interface{}{"name": "missing", "process_name": "sse-no-such-process"},
+		map[string]interface{}{"name": "unreadable", "pidfile": filepath.Join(dir, "missing.pid")},
+		map[string]interface{}{"name": "stale", "pidfile": stalePIDFile},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = watch.Collect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		actual   WatchedProcessStat
+		expected string
+	}{
+		{watch.Processes[0], fmt.Sprintf("pidfile true 1 [%d] ", os.Getpid())},
+		{watch.Processes[1], fmt.Sprintf("cmdline true 1 [%d] ", os.Getpid())},
+		{watch.Processes[2], "missing false 0 [] "},
+		{watch.Processes[3], "unreadable false 0 [] open " + filepath.Join(dir, "missing.pid") + ": no such file or directory"},
+		{watch.Processes[4], fmt.Sprintf("stale false 0 [] %s is stale, PID %d was reused", stalePIDFile, os.Getpid())},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			stat := test.actual
+			if actual := fmt.Sprint(stat.Name, " ", stat.Up, " ", stat.Instances, " ", stat.PIDs, " ", stat.Error); actual != test.expected {
+				t.Fatalf("expected '%s', got '%s'", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestObserve(t *testing.T) {
+	runs := []struct {
+		pids     []int32
+		expected string
+	}{
+		{[]int32{10, 11}, "false 0"},
+		{[]int32{10, 11, 12}, "false 0"}, // scaled up
+		{[]int32{10, 12, 13}, "true 1"},  // 11 was replaced by 13
+		{nil, "false 1"},                 // down
+		{[]int32{20}, "true 2"},          // back up under another PID
+		{[]int32{20}, "false 2"},
+	}
+
+	for i, run := range runs {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			restarted, restarts := observe("TestObserve", run.pids)
+			if actual := fmt.Sprint(restarted, " ", restarts); actual != run.expected {
+				t.Fatalf("expected '%s', got '%s'", run.expected, actual)
+			}
+		})
+	}
+}
+
+func TestLoadAvgStat_JSON(t *testing.T) {
+	jsonStr, err := json.Marshal(&LoadAvgStat{Load1: 0, Load5: 0.01, Load15: 1.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `["0","0.01","1.5"]`; string(jsonStr) != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, jsonStr)
+	}
+
+	var avg LoadAvgStat
+	if err = json.Unmarshal(jsonStr, &avg); err != nil {
+		t.Fatal(err)
+	}
+	if actual := fmt.Sprint(avg); actual != "{0 0.01 1.5}" {
+		t.Fatalf("expected '%s', got '%s'", "{0 0.01 1.5}", actual)
+	}
+	if err = json.Unmarshal([]byte(`["1","2"]`), &avg); err == nil {
+		t.Fatalf("expected an error for 2 values")
+	}
+}
+
+func TestProcesses_Metrics(t *testing.T) {
+	processes := Processes{
+		Total:  3,
+		States: map[string]int{"sleeping": 3},
+		TopCPU: []ProcessStat{
+			{PID: 10, Name: "nginx", CPUPercent: 10, RSS: 100, Threads: 1, OpenFDs: 5},
+			{PID: 11, Name: "nginx", CPUPercent: 5, RSS: 50, Threads: 1, OpenFDs: 3},
+		},
+		TopMemory: []ProcessStat{
+			{PID: 10, Name: "nginx", CPUPercent: 10, RSS: 100, Threads: 1, OpenFDs: 5},
+			{PID: 20, Name: "postgres", CPUPercent: 1, RSS: 200, Threads: 4, OpenFDs: -1},
+		},
+	}
+
+	var actual []string
+	for _, metric := range processes.Metrics() {
+		actual = append(actual, fmt.Sprint(metric.Measurement, ".", metric.Field, metric.Tags, "=", metric.Value))
+	}
+	expected := "[processes.totalmap[]=3 processes.countmap[state:sleeping]=3 " +
+		"process.cpu_percentmap[name:nginx]=15 process.rssmap[name:nginx]=150 process.threadsmap[name:nginx]=2 process.open_fdsmap[name:nginx]=8 " +
+		"process.cpu_percentmap[name:postgres]=1 process.rssmap[name:postgres]=200 process.threadsmap[name:postgres]=4]"
+	if fmt.Sprint(actual) != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, actual)
+	}
+}
@@ -0,0 +1,34 @@
+package collector
+
+// Metric is a single numeric reading of a collector, the common ground
+// of every output which is not the JSON report (Prometheus, InfluxDB...).
+type Metric struct {
+	// Measurement groups related metrics: cpu, mem, disk, net, system...
+	Measurement string
+
+	// Field names the reading within its measurement: user, used_percent...
+	Field string
+
+	// Tags tell apart the readings of a measurement: cpu=cpu0, device=sda...
+	Tags map[string]string
+
+	Value float64
+
+	// Counter is true for ever increasing values, false for gauges
+	Counter bool
+}
+
+// Measurer is implemented by the collectors which can describe their data as Metrics
+type Measurer interface {
+	Metrics() []Metric
+}
+
+// gauge returns a gauge Metric
+func gauge(measurement string, field string, tags map[string]string, value float64) Metric {
+	return Metric{Measurement: measurement, Field: field, Tags: tags, Value: value}
+}
+
+// counter returns a counter Metric
+func counter(measurement string, field string, tags map[string]string, value float64) Metric {
+	return Metric{Measurement: measurement, Field: field, Tags: tags, Value: value, Counter: true}
+}
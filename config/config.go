@@ -2,11 +2,15 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	sserror "github.com/jsanc623/ServerStatusEmitter/sphlog"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"path"
+	"reflect"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -16,54 +20,281 @@ const (
 	CollectorURI = "collector"
 	StatusURI    = "status"
 	Version      = "1.0"
+
+	defaultCollectFrequency = time.Second
+	defaultCollectorTimeout = 10 * time.Second
+	defaultRequestTimeout   = 30 * time.Second
 )
 
+// disabledCollectors are the collectors which only run once enabled in the
+// collectors section, since they are costlier than the others
+var disabledCollectors = map[string]bool{"processes": true}
+
+// staticCollectors are the collectors of information which rarely changes,
+// with how often they run unless stated otherwise in the collectors section
+var staticCollectors = map[string]time.Duration{
+	"cpu_info":        time.Hour,
+	"host_info":       time.Hour,
+	"disk_partitions": 5 * time.Minute,
+}
+
 // Config holds our application configuration
 type Config struct {
-	Mode           string
-	Mothership     string
-	Log            string
-	Identification identification
-	Settings       settings
-	Reporting      reporting
+	Mode           string         `json:"mode"`
+	Mothership     string         `json:"mothership"`
+	Log            string         `json:"log"`
+	Identification identification `json:"identification"`
+	Settings       settings       `json:"settings"`
+	TLS            tlsSettings    `json:"tls"`
+	Proxy          proxySettings  `json:"proxy"`
+	Transport      transport      `json:"transport"`
+
+	// unknown lists the keys of the configuration file which match no field
+	unknown []string
 }
 
 type identification struct {
-	ID           string // AccountID
-	Key          string // OrganizationID
-	Organization string // OrganizationName
-	Group        string // Group
-	Entity       string // Entity
+	ID           string `json:"id"`           // AccountID
+	Key          string `json:"key"`          // OrganizationID, signs the requests and is never sent
+	Organization string `json:"organization"` // OrganizationName
+	Group        string `json:"group"`        // Group
+	Entity       string `json:"entity"`       // Entity
+}
+
+type tlsSettings struct {
+	// CAFile is a PEM bundle of the certificate authorities trusted to sign
+	// the server certificates, instead of the ones of the system
+	CAFile string `json:"ca_file"`
+
+	// CertFile and KeyFile are the PEM client certificate and key presented
+	// to the servers which ask for one, for mutual TLS
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+
+	// ServerName overrides the name the server certificates are verified against
+	ServerName string `json:"server_name"`
+
+	// MinVersion is the lowest TLS version accepted: 1.0, 1.1, 1.2 or 1.3, defaults to 1.2
+	MinVersion string `json:"min_version"`
+
+	// InsecureSkipVerify accepts any server certificate, for labs only
+	InsecureSkipVerify bool `json:"insecure_skip_verify"`
+}
+
+type proxySettings struct {
+	// URL is the proxy every outbound request goes through, such as http://proxy:3128,
+	// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used when empty
+	URL string `json:"url"`
+
+	// Username and Password authenticate to the proxy, over the user info of the URL
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	// NoProxy lists the hosts reached directly: host names, which match their
+	// subdomains too, optionally with a port, IP addresses, CIDR ranges, or *
+	NoProxy []string `json:"no_proxy"`
+}
+
+type transport struct {
+	// MaxIdleConns caps the idle connections kept open across all hosts, defaults to 100
+	MaxIdleConns int `json:"max_idle_conns"`
+
+	// MaxIdleConnsPerHost caps the idle connections kept open per host, defaults to 2
+	MaxIdleConnsPerHost int `json:"max_idle_conns_per_host"`
+
+	// MaxConnsPerHost caps the connections per host, unlimited when 0
+	MaxConnsPerHost int `json:"max_conns_per_host"`
+
+	// IdleConnTimeoutSeconds is how long an idle connection is kept open, defaults to 90
+	IdleConnTimeoutSeconds int `json:"idle_conn_timeout_seconds"`
+
+	// DialTimeoutSeconds bounds the establishment of a connection, defaults to 30
+	DialTimeoutSeconds int `json:"dial_timeout_seconds"`
+
+	// TLSHandshakeTimeoutSeconds bounds the TLS handshake, defaults to 10
+	TLSHandshakeTimeoutSeconds int `json:"tls_handshake_timeout_seconds"`
+
+	// ResponseHeaderTimeoutSeconds bounds the wait for the response headers once
+	// the request is sent, only the request timeout applies when 0
+	ResponseHeaderTimeoutSeconds int `json:"response_header_timeout_seconds"`
 }
 
 type settings struct {
-	Reporting reporting
-	System    system
-	Disk      disk
+	Reporting  reporting            `json:"reporting"`
+	System     system               `json:"system"`
+	Disk       disk                 `json:"disk"`
+	Collectors map[string]collector `json:"collectors"`
+	Prometheus prometheus           `json:"prometheus"`
+	Sinks      []sink               `json:"sinks"`
+	Watch      []watch              `json:"watch"`
+}
+
+type watch struct {
+	// Name identifies the watched process in the reports
+	Name string `json:"name"`
+
+	// ProcessName matches the processes by their exact name, such as nginx
+	ProcessName string `json:"process_name"`
+
+	// Cmdline matches the processes by a regular expression on their command line
+	Cmdline string `json:"cmdline"`
+
+	// PIDFile matches the process whose PID is written in the file
+	PIDFile string `json:"pidfile"`
+}
+
+type sink struct {
+	// Type is the kind of sink, such as mothership, file or stdout
+	Type string `json:"type"`
+
+	// Name tells sinks of the same type apart and names their spool directory, defaults to the type
+	Name string `json:"name"`
+
+	// Options are handed to the sink as they are
+	Options map[string]interface{} `json:"options"`
+
+	// Retry overrides the reporting retry settings for this sink, field by field
+	Retry retry `json:"retry"`
+}
+
+type prometheus struct {
+	// Enabled serves the latest snapshot in the Prometheus text format
+	Enabled bool `json:"enabled"`
+
+	// ListenAddress is the address the exporter listens on, such as :9274
+	ListenAddress string `json:"listen_address"`
+
+	// Path is where the metrics are served, defaults to /metrics
+	Path string `json:"path"`
+}
+
+type collector struct {
+	// Enabled turns the collector on or off, collectors are enabled unless
+	// stated otherwise, except for the ones in disabledCollectors, the
+	// watch collector, which is enabled once processes are watched, and the
+	// disk_partitions collector, enabled by the disk include_partition_data
+	Enabled *bool `json:"enabled"`
+
+	// IntervalSeconds is how often the collector runs, defaults to the
+	// reporting collect_frequency_seconds, or for the staticCollectors to theirs
+	IntervalSeconds int `json:"interval_seconds"`
+
+	// TimeoutSeconds is how long the collector may run before it is abandoned,
+	// defaults to the reporting collector_timeout_seconds
+	TimeoutSeconds int `json:"timeout_seconds"`
+
+	// Options are handed to the collector as they are
+	Options map[string]interface{} `json:"options"`
 }
 
 type disk struct {
-	IncludePartitionData bool
+	IncludePartitionData bool `json:"include_partition_data"`
 }
 
 type system struct {
-	Hostname     string
-	IPAddress    string
-	IncludeUsers bool
+	Hostname     string `json:"hostname"`
+	IPAddress    string `json:"ip_address"`
+	IncludeUsers bool   `json:"include_users"`
 }
 
 type reporting struct {
 	// CollectFrequencySeconds tells us how often to collect a snapshot and store it in cache
-	CollectFrequencySeconds int
+	CollectFrequencySeconds int `json:"collect_frequency_seconds"`
 
 	// ReportFrequencySeconds tells us how often to report all snapshots in cache to mothership
-	ReportFrequencySeconds int
+	ReportFrequencySeconds int `json:"report_frequency_seconds"`
+
+	// Spool tells us where to keep batches which could not be reported to the mothership
+	Spool spool `json:"spool"`
+
+	// RequestTimeoutSeconds bounds every request made to the mothership, including reading the response
+	RequestTimeoutSeconds int `json:"request_timeout_seconds"`
+
+	// Retry tells us how to retry reports the mothership did not accept
+	Retry retry `json:"retry"`
+
+	// CollectorTimeoutSeconds is how long a collector may run before it is abandoned
+	CollectorTimeoutSeconds int `json:"collector_timeout_seconds"`
+
+	// ShutdownTimeoutSeconds bounds the final report made when we are asked to stop
+	ShutdownTimeoutSeconds int `json:"shutdown_timeout_seconds"`
+
+	// Counters tells whether snapshots carry the cumulative counters, the rates derived from them, or both, defaults to both
+	Counters string `json:"counters"`
+
+	// Compression is the Content-Encoding of the reports to the mothership: none, gzip or zstd, defaults to none
+	Compression string `json:"compression"`
+
+	// Format is the form batches are sent in by the JSON sinks, raw, saveable or aggregated, defaults to raw
+	Format string `json:"format"`
+}
+
+type retry struct {
+	// MaxAttempts is the number of attempts made per report, including the first one
+	MaxAttempts int `json:"max_attempts"`
+
+	// BaseDelayMilliseconds is the delay before the first retry, doubled on every following retry
+	BaseDelayMilliseconds int `json:"base_delay_ms"`
+
+	// MaxDelayMilliseconds caps the delay between two attempts
+	MaxDelayMilliseconds int `json:"max_delay_ms"`
+
+	// Jitter is the fraction (0 to 1) of each delay which is randomized
+	Jitter float64 `json:"jitter"`
+}
+
+type spool struct {
+	// Directory holds the spooled segment files, spooling is disabled when empty
+	Directory string `json:"directory"`
+
+	// MaxBytes caps the total size of the spool, the oldest segments are dropped
+	// first. It is shared evenly by the sinks, which each spool on their own.
+	MaxBytes int64 `json:"max_bytes"`
+
+	// MaxAgeSeconds drops segments older than this
+	MaxAgeSeconds int `json:"max_age_seconds"`
+}
+
+var (
+	// live holds the *Config every package reads through Current
+	live atomic.Value
+
+	// file is the path of the configuration file
+	file = configFile
+)
+
+// Current returns the live configuration. It is shared between
+// goroutines and must not be modified, Store a modified copy instead.
+func Current() *Config {
+	if C, ok := live.Load().(*Config); ok {
+		return C
+	}
+	return &Config{}
+}
+
+// Store atomically replaces the live configuration
+func Store(C *Config) {
+	live.Store(C)
 }
 
 // Load ingests a JSON config file into our Config struct
+// and exits if it cannot be parsed or is not valid.
 func (C *Config) Load() {
-	jsonFk, err := os.Open(configFile)
+	parsed, err := Parse(file)
 	sserror.LogFatalError(err)
+	sserror.LogFatalError(parsed.Validate())
+
+	*C = *parsed
+}
+
+// Parse reads a JSON, YAML or TOML config file, selected by its
+// extension, and returns the Config it holds, overridden by the
+// SSE_* environment variables.
+func Parse(file string) (*Config, error) {
+	jsonFk, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
 
 	defer func() {
 		err = jsonFk.Close()
@@ -71,10 +302,41 @@ func (C *Config) Load() {
 	}()
 
 	byteValue, err := ioutil.ReadAll(jsonFk)
-	sserror.LogFatalError(err)
+	if err != nil {
+		return nil, err
+	}
 
-	err = json.Unmarshal(byteValue, &C)
-	sserror.LogFatalError(err)
+	byteValue, err = toJSON(byteValue, FormatOf(file))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	var C Config
+	if err = json.Unmarshal(byteValue, &C); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	var raw map[string]interface{}
+	if err = json.Unmarshal(byteValue, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	C.unknown = unknownKeys(raw, reflect.TypeOf(C), "")
+
+	if err = C.applyEnvironment(os.LookupEnv); err != nil {
+		return nil, fmt.Errorf("environment: %s", err)
+	}
+
+	return &C, nil
+}
+
+// File returns the path of the configuration file
+func File() string {
+	return file
+}
+
+// SetFile changes the path of the configuration file Load and File use
+func SetFile(path string) {
+	file = path
 }
 
 // GetURL returns the mothership URL with or without an appended URI
@@ -83,6 +345,7 @@ func (C *Config) GetURL(uri string) string {
 		u, err := url.Parse(C.Mothership)
 		if err != nil {
 			sserror.LogError(err)
+			return ""
 		}
 		u.Path = path.Join(u.Path, uriBase)
 		u.Path = path.Join(u.Path, Version)
@@ -107,7 +370,92 @@ func (C *Config) GetStatusURL() string {
 	return C.GetURL(StatusURI)
 }
 
+// CollectorEnabled tells us if the named collector should run
+func (C *Config) CollectorEnabled(name string) bool {
+	settings, exists := C.Settings.Collectors[name]
+	if !exists || settings.Enabled == nil {
+		switch name {
+		case "watch":
+			return len(C.Settings.Watch) > 0
+		case "disk_partitions":
+			return C.Settings.Disk.IncludePartitionData
+		}
+		return !disabledCollectors[name]
+	}
+	return *settings.Enabled
+}
+
+// CollectorInterval returns how often the named collector runs
+func (C *Config) CollectorInterval(name string) time.Duration {
+	if seconds := C.Settings.Collectors[name].IntervalSeconds; seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if interval, static := staticCollectors[name]; static {
+		return interval
+	}
+	if seconds := C.Settings.Reporting.CollectFrequencySeconds; seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return defaultCollectFrequency
+}
+
+// CollectorTimeout returns how long the named collector may run
+func (C *Config) CollectorTimeout(name string) time.Duration {
+	if seconds := C.Settings.Collectors[name].TimeoutSeconds; seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if seconds := C.Settings.Reporting.CollectorTimeoutSeconds; seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return defaultCollectorTimeout
+}
+
+// RequestTimeout returns how long an outbound HTTP request may take, reading its response included
+func (C *Config) RequestTimeout() time.Duration {
+	if seconds := C.Settings.Reporting.RequestTimeoutSeconds; seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return defaultRequestTimeout
+}
+
+// CollectorOptions returns the options of the named collector. The system
+// and watch settings are passed on to their collectors unless overridden.
+func (C *Config) CollectorOptions(name string) map[string]interface{} {
+	options := make(map[string]interface{})
+
+	switch name {
+	case "system":
+		options["include_users"] = C.Settings.System.IncludeUsers
+	case "watch":
+		// the watched processes are passed on the way they would be read from JSON
+		watched := make([]interface{}, 0, len(C.Settings.Watch))
+		for _, settings := range C.Settings.Watch {
+			watched = append(watched, map[string]interface{}{
+				"name":         settings.Name,
+				"process_name": settings.ProcessName,
+				"cmdline":      settings.Cmdline,
+				"pidfile":      settings.PIDFile,
+			})
+		}
+		options["processes"] = watched
+	}
+
+	for key, value := range C.Settings.Collectors[name].Options {
+		options[key] = value
+	}
+	return options
+}
+
+// SinkName returns the name of the sink at index in the sinks list
+func (C *Config) SinkName(index int) string {
+	if name := C.Settings.Sinks[index].Name; name != "" {
+		return name
+	}
+	return C.Settings.Sinks[index].Type
+}
+
 // MarshalJSON returns a JSON representation of our Config struct
 func (C *Config) MarshalJSON() ([]byte, error) {
-	return json.Marshal(C)
+	type plain Config // without this method, which would recurse forever
+	return json.Marshal((*plain)(C))
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 )
 
 var TestConfig Config
@@ -120,3 +121,65 @@ func TestConfig_GetCollectorURL(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_CollectorEnabled(t *testing.T) {
+	enabled, disabled := true, false
+	C := Config{}
+	C.Settings.Collectors = map[string]collector{"cpu": {Enabled: &disabled}, "processes": {Enabled: &enabled}}
+
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"cpu", false},
+		{"memory", true},
+		{"processes", true},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			if actual := C.CollectorEnabled(test.name); actual != test.expected {
+				t.Fatalf("expected '%t', got '%t'", test.expected, actual)
+			}
+		})
+	}
+
+	if (&Config{}).CollectorEnabled("processes") {
+		t.Fatalf("expected the processes collector to be disabled by default")
+	}
+
+	C.Settings.Watch = []watch{{Name: "nginx", ProcessName: "nginx"}}
+	if !C.CollectorEnabled("watch") || (&Config{}).CollectorEnabled("watch") {
+		t.Fatalf("expected the watch collector to be enabled once processes are watched")
+	}
+
+	C.Settings.Disk.IncludePartitionData = true
+	if !C.CollectorEnabled("disk_partitions") || (&Config{}).CollectorEnabled("disk_partitions") {
+		t.Fatalf("expected the disk_partitions collector to be enabled by include_partition_data")
+	}
+}
+
+func TestConfig_CollectorInterval(t *testing.T) {
+	C := Config{}
+	C.Settings.Reporting.CollectFrequencySeconds = 5
+	C.Settings.Collectors = map[string]collector{"cpu": {IntervalSeconds: 2}, "host_info": {IntervalSeconds: 60}}
+
+	tests := []struct {
+		name     string
+		expected time.Duration
+	}{
+		{"cpu", 2 * time.Second},
+		{"memory", 5 * time.Second},
+		{"host_info", time.Minute},
+		{"cpu_info", time.Hour},
+		{"disk_partitions", 5 * time.Minute},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			if actual := C.CollectorInterval(test.name); actual != test.expected {
+				t.Fatalf("expected '%s', got '%s'", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	// EnvironmentPrefix starts the name of every environment variable we read
+	EnvironmentPrefix = "SSE_"
+
+	// EnvironmentFile names the environment variable holding the configuration file path
+	EnvironmentFile = EnvironmentPrefix + "CONFIG"
+
+	// retiredReporting prefixes the variables of the reporting section which
+	// used to be at the top of the configuration, and was never read there
+	retiredReporting = EnvironmentPrefix + "REPORTING"
+)
+
+// SearchPath lists where the configuration file is looked for, in order,
+// when its path is given neither by the -config flag nor by SSE_CONFIG.
+// A leading ~ stands for the home directory of the user.
+var SearchPath = []string{
+	configFile,
+	"~/.config/sse/config.json",
+	"/usr/local/etc/sse/config.json",
+	"/etc/sse/config.json",
+}
+
+// Locate returns the path of the configuration file: path if it is not
+// empty, else the SSE_CONFIG environment variable, else the first file
+// of the SearchPath which exists.
+func Locate(path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+
+	if path = os.Getenv(EnvironmentFile); path != "" {
+		return path, nil
+	}
+
+	for _, candidate := range SearchPath {
+		if strings.HasPrefix(candidate, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				continue
+			}
+			candidate = filepath.Join(home, candidate[2:])
+		}
+
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("no configuration file found, use -config or %s, or create one of: %s", EnvironmentFile, strings.Join(SearchPath, ", "))
+}
+
+// applyEnvironment overrides the configuration with the SSE_* environment
+// variables found by lookup. Every field can be overridden, except the ones
+// in maps, by a variable named after its JSON path: SSE_IDENTIFICATION_KEY
+// overrides identification.key. Lists are comma separated. The variables
+// of the former top level reporting section are rejected.
+func (C *Config) applyEnvironment(lookup func(string) (string, bool)) error {
+	var problems []string
+	overrideFields(reflect.ValueOf(C).Elem(), strings.TrimSuffix(EnvironmentPrefix, "_"), lookup, &problems)
+
+	var retired reporting
+	overrideFields(reflect.ValueOf(&retired).Elem(), retiredReporting, func(name string) (string, bool) {
+		if _, set := lookup(name); set {
+			problems = append(problems, fmt.Sprintf("%s is not read, use %s", name, EnvironmentPrefix+"SETTINGS_"+strings.TrimPrefix(name, EnvironmentPrefix)))
+		}
+		return "", false
+	}, &problems)
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// overrideFields walks the fields of the struct v and overrides the ones set in the environment
+func overrideFields(v reflect.Value, prefix string, lookup func(string) (string, bool), problems *[]string) {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+
+		name := field.Name
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		name = prefix + "_" + strings.ToUpper(name)
+
+		value := v.Field(i)
+		switch value.Kind() {
+		case reflect.Struct:
+			overrideFields(value, name, lookup, problems)
+			continue
+		case reflect.Map, reflect.Interface:
+			continue
+		}
+
+		raw, set := lookup(name)
+		if !set {
+			continue
+		}
+
+		if err := setValue(value, raw); err != nil {
+			*problems = append(*problems, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+}
+
+// setValue parses raw into v according to its kind
+func setValue(v reflect.Value, raw string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Ptr:
+		value := reflect.New(v.Type().Elem())
+		if err := setValue(value.Elem(), raw); err != nil {
+			return err
+		}
+		v.Set(value)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("cannot be set from the environment")
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("cannot be set from the environment")
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestConfig_ApplyEnvironment(t *testing.T) {
+	tests := []struct {
+		environment map[string]string
+		actual      func(C *Config) interface{}
+		expected    interface{}
+		invalid     bool
+	}{
+		{map[string]string{}, func(C *Config) interface{} { return C.Identification.Key }, "test-key", false},
+		{map[string]string{"SSE_IDENTIFICATION_KEY": "secret"}, func(C *Config) interface{} { return C.Identification.Key }, "secret", false},
+		{map[string]string{"SSE_MOTHERSHIP": "https://example.com"}, func(C *Config) interface{} { return C.Mothership }, "https://example.com", false},
+		{map[string]string{"SSE_SETTINGS_REPORTING_COLLECT_FREQUENCY_SECONDS": "5"}, func(C *Config) interface{} { return C.Settings.Reporting.CollectFrequencySeconds }, 5, false},
+		{map[string]string{"SSE_SETTINGS_REPORTING_RETRY_JITTER": "0.25"}, func(C *Config) interface{} { return C.Settings.Reporting.Retry.Jitter }, 0.25, false},
+		{map[string]string{"SSE_SETTINGS_DISK_INCLUDE_PARTITION_DATA": "false"}, func(C *Config) interface{} { return C.Settings.Disk.IncludePartitionData }, false, false},
+		{map[string]string{"SSE_SETTINGS_SYSTEM_INCLUDE_USERS": "maybe"}, func(C *Config) interface{} { return C.Settings.System.IncludeUsers }, false, true},
+		{map[string]string{"SSE_REPORTING_COLLECT_FREQUENCY_SECONDS": "5"}, func(C *Config) interface{} { return C.Settings.Reporting.CollectFrequencySeconds }, TestConfig.Settings.Reporting.CollectFrequencySeconds, true},
+		{map[string]string{"SSE_REPORTING_SPOOL_DIRECTORY": "/tmp"}, func(C *Config) interface{} { return C.Settings.Reporting.Spool.Directory }, TestConfig.Settings.Reporting.Spool.Directory, true},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			C := TestConfig
+			err := C.applyEnvironment(func(name string) (string, bool) {
+				value, set := test.environment[name]
+				return value, set
+			})
+			if (err != nil) != test.invalid {
+				t.Fatalf("expected error '%t', got '%v'", test.invalid, err)
+			}
+			if actual := test.actual(&C); actual != test.expected {
+				t.Fatalf("expected '%v', got '%v'", test.expected, actual)
+			}
+		})
+	}
+}
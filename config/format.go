@@ -0,0 +1,197 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The configuration file formats, selected by file extension
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// Formats lists the supported configuration file formats
+var Formats = []string{FormatJSON, FormatYAML, FormatTOML}
+
+// secretKeys are the keys whose values are redacted from dumps
+var secretKeys = []string{"key", "password", "token", "secret"}
+
+// secretMaps are the keys whose objects have all their values redacted from
+// dumps, such as the headers of the sinks which carry their credentials
+var secretMaps = []string{"headers"}
+
+// redacted replaces the value of secrets in dumps
+const redacted = "REDACTED"
+
+// FormatOf returns the format of a configuration file from its extension,
+// files which are neither YAML nor TOML are read as JSON.
+func FormatOf(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	}
+	return FormatJSON
+}
+
+// toJSON converts a YAML or TOML document to JSON, so every format
+// is decoded and validated through the same JSON field tags.
+func toJSON(data []byte, format string) ([]byte, error) {
+	var document interface{}
+
+	switch format {
+	case FormatJSON:
+		return data, nil
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &document); err != nil {
+			return nil, err
+		}
+	case FormatTOML:
+		var table map[string]interface{}
+		if err := toml.Unmarshal(data, &table); err != nil {
+			return nil, err
+		}
+		document = table
+	default:
+		return nil, fmt.Errorf("unknown format %q, use one of: %s", format, strings.Join(Formats, ", "))
+	}
+
+	if document == nil {
+		document = map[string]interface{}{}
+	}
+	return json.Marshal(normalize(document))
+}
+
+// Dump returns the configuration in the given format, with the secrets redacted
+func (C *Config) Dump(format string) ([]byte, error) {
+	jsonStr, err := C.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(jsonStr))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err = decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	document = redact(normalize(document))
+
+	var buffer bytes.Buffer
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(&buffer)
+		encoder.SetIndent("", "    ")
+		err = encoder.Encode(document)
+	case FormatYAML:
+		var out []byte
+		out, err = yaml.Marshal(document)
+		buffer.Write(out)
+	case FormatTOML:
+		err = toml.NewEncoder(&buffer).Encode(document)
+	default:
+		err = fmt.Errorf("unknown format %q, use one of: %s", format, strings.Join(Formats, ", "))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// normalize turns the generic documents of every decoder into JSON
+// compatible ones: maps get string keys, numbers get a concrete type,
+// and null values are dropped since TOML has no way to write them.
+func normalize(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			if item != nil {
+				object[fmt.Sprint(key)] = normalize(item)
+			}
+		}
+		return object
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			if item != nil {
+				object[key] = normalize(item)
+			}
+		}
+		return object
+	case []interface{}:
+		list := make([]interface{}, 0, len(value))
+		for _, item := range value {
+			list = append(list, normalize(item))
+		}
+		return list
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		f, _ := value.Float64()
+		return f
+	}
+	return value
+}
+
+// redact replaces the non empty values of the secret keys of a normalized
+// document, the values of its secret maps, and the user information of its URLs
+func redact(value interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		return redactURL(value)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if secret, ok := value[key].(string); ok && secret != "" && contains(secretKeys, strings.ToLower(key)) {
+				value[key] = redacted
+				continue
+			}
+			if secrets, ok := value[key].(map[string]interface{}); ok && contains(secretMaps, strings.ToLower(key)) {
+				for name := range secrets {
+					secrets[name] = redacted
+				}
+				continue
+			}
+			value[key] = redact(value[key])
+		}
+	case []interface{}:
+		for index, item := range value {
+			value[index] = redact(item)
+		}
+	}
+	return value
+}
+
+// redactURL replaces the user information of a URL, such as the user:pass@
+// of a proxy, other strings are returned as they are
+func redactURL(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	u.User = url.User(redacted)
+	return u.String()
+}
@@ -0,0 +1,318 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// KnownCollectors returns the names of the available collectors, which
+// the collectors section is validated against. It is set by the runner
+// package, and the collector names are not checked while it is nil.
+var KnownCollectors func() []string
+
+// KnownSinks returns the types of the available sinks, set by the runner
+// package like KnownCollectors.
+var KnownSinks func() []string
+
+// KnownFormats returns the reporting formats batches can be sent in, set by
+// the runner package like KnownCollectors.
+var KnownFormats func() []string
+
+// TLSVersions lists the values of tls.min_version
+var TLSVersions = []string{"1.0", "1.1", "1.2", "1.3"}
+
+// sinkNameRgx matches the sink names which are safe to use as a directory name
+var sinkNameRgx = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// ValidationError lists every problem found in a configuration
+type ValidationError []string
+
+// Error returns all the problems, one per line
+func (V ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(V, "\n  - ")
+}
+
+// Validate checks the configuration and returns a ValidationError
+// listing every problem found, or nil if the configuration is valid.
+func (C *Config) Validate() error {
+	var problems ValidationError
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	atLeast := func(key string, value int64, min int64) {
+		if value < min {
+			addf("%s must be at least %d, got %d", key, min, value)
+		}
+	}
+
+	for _, key := range C.unknown {
+		addf("unknown key %q, check its spelling and where it is nested", key)
+	}
+
+	if C.Mode == "" {
+		addf("mode is required")
+	}
+
+	if C.Mothership == "" {
+		addf("mothership is required")
+	} else if u, err := url.Parse(C.Mothership); err != nil {
+		addf("mothership %q is not a valid URL: %s", C.Mothership, err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		addf("mothership %q must use the http or https scheme", C.Mothership)
+	} else if u.Host == "" {
+		addf("mothership %q has no host", C.Mothership)
+	}
+
+	if C.Log == "" {
+		addf("log is required, set it to the path of the log file")
+	}
+
+	required := []struct {
+		key   string
+		value string
+	}{
+		{"identification.id", C.Identification.ID},
+		{"identification.key", C.Identification.Key},
+		{"identification.organization", C.Identification.Organization},
+		{"identification.group", C.Identification.Group},
+		{"identification.entity", C.Identification.Entity},
+	}
+	for _, field := range required {
+		if strings.TrimSpace(field.value) == "" {
+			addf("%s is required", field.key)
+		}
+	}
+
+	reporting := C.Settings.Reporting
+	atLeast("settings.reporting.collect_frequency_seconds", int64(reporting.CollectFrequencySeconds), 1)
+	atLeast("settings.reporting.report_frequency_seconds", int64(reporting.ReportFrequencySeconds), 1)
+	if reporting.CollectFrequencySeconds > 0 && reporting.ReportFrequencySeconds > 0 && reporting.ReportFrequencySeconds < reporting.CollectFrequencySeconds {
+		addf("settings.reporting.report_frequency_seconds (%d) must not be less than collect_frequency_seconds (%d)", reporting.ReportFrequencySeconds, reporting.CollectFrequencySeconds)
+	}
+	atLeast("settings.reporting.request_timeout_seconds", int64(reporting.RequestTimeoutSeconds), 0)
+	atLeast("settings.reporting.collector_timeout_seconds", int64(reporting.CollectorTimeoutSeconds), 0)
+	atLeast("settings.reporting.shutdown_timeout_seconds", int64(reporting.ShutdownTimeoutSeconds), 0)
+	atLeast("settings.reporting.spool.max_bytes", reporting.Spool.MaxBytes, 0)
+	atLeast("settings.reporting.spool.max_age_seconds", int64(reporting.Spool.MaxAgeSeconds), 0)
+	checkRetry := func(key string, retry retry) {
+		atLeast(key+".max_attempts", int64(retry.MaxAttempts), 0)
+		atLeast(key+".base_delay_ms", int64(retry.BaseDelayMilliseconds), 0)
+		atLeast(key+".max_delay_ms", int64(retry.MaxDelayMilliseconds), 0)
+		if retry.BaseDelayMilliseconds > 0 && retry.MaxDelayMilliseconds > 0 && retry.BaseDelayMilliseconds > retry.MaxDelayMilliseconds {
+			addf("%s.base_delay_ms (%d) must not be greater than max_delay_ms (%d)", key, retry.BaseDelayMilliseconds, retry.MaxDelayMilliseconds)
+		}
+		if retry.Jitter < 0 || retry.Jitter > 1 {
+			addf("%s.jitter must be between 0 and 1, got %g", key, retry.Jitter)
+		}
+	}
+	checkRetry("settings.reporting.retry", reporting.Retry)
+	if reporting.Counters != "" && reporting.Counters != "raw" && reporting.Counters != "derived" && reporting.Counters != "both" {
+		addf("settings.reporting.counters must be raw, derived or both, got %q", reporting.Counters)
+	}
+	if reporting.Compression != "" && reporting.Compression != "none" && reporting.Compression != "gzip" && reporting.Compression != "zstd" {
+		addf("settings.reporting.compression must be none, gzip or zstd, got %q", reporting.Compression)
+	}
+	if reporting.Format != "" && KnownFormats != nil && !contains(KnownFormats(), reporting.Format) {
+		addf("settings.reporting.format %q is not a known format, available formats are: %s", reporting.Format, strings.Join(KnownFormats(), ", "))
+	}
+
+	if C.Settings.Prometheus.Enabled {
+		if C.Settings.Prometheus.ListenAddress == "" {
+			addf("settings.prometheus.listen_address is required when the exporter is enabled, such as \":9274\"")
+		}
+		if C.Settings.Prometheus.Path != "" && !strings.HasPrefix(C.Settings.Prometheus.Path, "/") {
+			addf("settings.prometheus.path %q must start with /", C.Settings.Prometheus.Path)
+		}
+	}
+
+	if (C.TLS.CertFile == "") != (C.TLS.KeyFile == "") {
+		addf("tls.cert_file and tls.key_file must be set together")
+	}
+	if C.TLS.MinVersion != "" && !contains(TLSVersions, C.TLS.MinVersion) {
+		addf("tls.min_version must be one of %s, got %q", strings.Join(TLSVersions, ", "), C.TLS.MinVersion)
+	}
+
+	if C.Proxy.URL != "" {
+		if u, err := url.Parse(C.Proxy.URL); err != nil {
+			addf("proxy.url %q is not a valid URL: %s", C.Proxy.URL, err)
+		} else if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" {
+			addf("proxy.url %q must use the http, https or socks5 scheme", C.Proxy.URL)
+		} else if u.Host == "" {
+			addf("proxy.url %q has no host", C.Proxy.URL)
+		}
+	}
+	atLeast("transport.max_idle_conns", int64(C.Transport.MaxIdleConns), 0)
+	atLeast("transport.max_idle_conns_per_host", int64(C.Transport.MaxIdleConnsPerHost), 0)
+	atLeast("transport.max_conns_per_host", int64(C.Transport.MaxConnsPerHost), 0)
+	atLeast("transport.idle_conn_timeout_seconds", int64(C.Transport.IdleConnTimeoutSeconds), 0)
+	atLeast("transport.dial_timeout_seconds", int64(C.Transport.DialTimeoutSeconds), 0)
+	atLeast("transport.tls_handshake_timeout_seconds", int64(C.Transport.TLSHandshakeTimeoutSeconds), 0)
+	atLeast("transport.response_header_timeout_seconds", int64(C.Transport.ResponseHeaderTimeoutSeconds), 0)
+
+	var known []string
+	if KnownCollectors != nil {
+		known = KnownCollectors()
+	}
+
+	names := make([]string, 0, len(C.Settings.Collectors))
+	for name := range C.Settings.Collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		settings := C.Settings.Collectors[name]
+		if KnownCollectors != nil && !contains(known, name) {
+			addf("settings.collectors.%s is not a known collector, available collectors are: %s", name, strings.Join(known, ", "))
+		}
+		atLeast("settings.collectors."+name+".interval_seconds", int64(settings.IntervalSeconds), 0)
+		atLeast("settings.collectors."+name+".timeout_seconds", int64(settings.TimeoutSeconds), 0)
+	}
+
+	var sinkTypes []string
+	if KnownSinks != nil {
+		sinkTypes = KnownSinks()
+	}
+
+	sinkNames := make(map[string]bool)
+	for index, settings := range C.Settings.Sinks {
+		key := fmt.Sprintf("settings.sinks[%d]", index)
+		if settings.Type == "" {
+			addf("%s.type is required", key)
+		} else if KnownSinks != nil && !contains(sinkTypes, settings.Type) {
+			addf("%s.type %q is not a known sink, available sinks are: %s", key, settings.Type, strings.Join(sinkTypes, ", "))
+		}
+
+		if name := C.SinkName(index); name != "" {
+			if !sinkNameRgx.MatchString(name) {
+				addf("%s.name %q may only hold letters, digits, '.', '_' and '-'", key, name)
+			} else if sinkNames[name] {
+				addf("%s.name %q is used by another sink, give each sink of the same type a name", key, name)
+			}
+			sinkNames[name] = true
+		}
+
+		checkRetry(key+".retry", settings.Retry)
+	}
+
+	watchNames := make(map[string]bool)
+	for index, settings := range C.Settings.Watch {
+		key := fmt.Sprintf("settings.watch[%d]", index)
+		if strings.TrimSpace(settings.Name) == "" {
+			addf("%s.name is required", key)
+		} else if watchNames[settings.Name] {
+			addf("%s.name %q is used by another watched process", key, settings.Name)
+		}
+		watchNames[settings.Name] = true
+
+		matchers := 0
+		for _, matcher := range []string{settings.ProcessName, settings.Cmdline, settings.PIDFile} {
+			if matcher != "" {
+				matchers++
+			}
+		}
+		if matchers != 1 {
+			addf("%s must set exactly one of process_name, cmdline and pidfile", key)
+		}
+		if settings.Cmdline != "" {
+			if _, err := regexp.Compile(settings.Cmdline); err != nil {
+				addf("%s.cmdline is not a valid regular expression: %s", key, err)
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return problems
+}
+
+// contains tells us if list holds value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// unknownKeys returns the keys of raw, and of the objects nested in it,
+// which match no field of the struct type t. Keys are matched the way
+// encoding/json matches them: by JSON tag or field name, ignoring case.
+func unknownKeys(raw map[string]interface{}, t reflect.Type, prefix string) []string {
+	var unknown []string
+
+	for key, value := range raw {
+		keyPath := key
+		if prefix != "" {
+			keyPath = prefix + "." + key
+		}
+
+		field, found := fieldByKey(t, key)
+		if !found {
+			unknown = append(unknown, keyPath)
+			continue
+		}
+		unknown = append(unknown, unknownIn(value, field.Type, keyPath)...)
+	}
+
+	sort.Strings(unknown)
+	return unknown
+}
+
+// unknownIn returns the unknown keys nested in a value of type t
+func unknownIn(value interface{}, t reflect.Type, keyPath string) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var unknown []string
+	switch t.Kind() {
+	case reflect.Struct:
+		if object, ok := value.(map[string]interface{}); ok {
+			unknown = unknownKeys(object, t, keyPath)
+		}
+	case reflect.Map:
+		if object, ok := value.(map[string]interface{}); ok {
+			for key, item := range object {
+				unknown = append(unknown, unknownIn(item, t.Elem(), keyPath+"."+key)...)
+			}
+		}
+	case reflect.Slice:
+		if list, ok := value.([]interface{}); ok {
+			for index, item := range list {
+				unknown = append(unknown, unknownIn(item, t.Elem(), fmt.Sprintf("%s[%d]", keyPath, index))...)
+			}
+		}
+	}
+
+	return unknown
+}
+
+// fieldByKey returns the exported field of the struct type t a JSON key decodes into
+func fieldByKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+
+		name := field.Name
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		modify   func(C *Config)
+		expected []string
+	}{
+		{func(C *Config) {}, nil},
+		{func(C *Config) { C.Mothership = "" }, []string{"mothership is required"}},
+		{func(C *Config) { C.Mothership = "mothership.serverstatusmonitoring.com" }, []string{"must use the http or https scheme"}},
+		{func(C *Config) { C.Mothership = "http://" }, []string{"has no host"}},
+		{func(C *Config) { C.Identification.Key = " " }, []string{"identification.key is required"}},
+		{func(C *Config) { C.Settings.Reporting.CollectFrequencySeconds = 0 }, []string{"collect_frequency_seconds must be at least 1"}},
+		{func(C *Config) { C.Settings.Reporting.ReportFrequencySeconds = 0 }, []string{"report_frequency_seconds must be at least 1"}},
+		{func(C *Config) { C.Settings.Reporting.CollectFrequencySeconds = 60 }, []string{"must not be less than collect_frequency_seconds"}},
+		{func(C *Config) { C.Settings.Reporting.Retry.Jitter = 2 }, []string{"jitter must be between 0 and 1"}},
+		{func(C *Config) { C.Settings.Sinks = []sink{{}} }, []string{"settings.sinks[0].type is required"}},
+		{func(C *Config) { C.Settings.Sinks = []sink{{Type: "file"}, {Type: "file"}} }, []string{`settings.sinks[1].name "file" is used by another sink`}},
+		{func(C *Config) { C.Settings.Sinks = []sink{{Type: "file", Name: "../archive"}} }, []string{"may only hold letters"}},
+		{func(C *Config) { C.Settings.Sinks = []sink{{Type: "stdout", Retry: retry{Jitter: -1}}} }, []string{"settings.sinks[0].retry.jitter must be between 0 and 1"}},
+		{func(C *Config) {
+			C.Settings.Watch = []watch{{Name: "nginx", ProcessName: "nginx", PIDFile: "/run/nginx.pid"}}
+		}, []string{"settings.watch[0] must set exactly one of process_name, cmdline and pidfile"}},
+		{func(C *Config) { C.Settings.Watch = []watch{{Name: "worker", Cmdline: "worker ("}} }, []string{"settings.watch[0].cmdline is not a valid regular expression"}},
+		{func(C *Config) { C.TLS.CertFile = "client.crt" }, []string{"tls.cert_file and tls.key_file must be set together"}},
+		{func(C *Config) { C.TLS.MinVersion = "1.4" }, []string{`tls.min_version must be one of 1.0, 1.1, 1.2, 1.3, got "1.4"`}},
+		{func(C *Config) { C.Proxy.URL = "ftp://proxy:21" }, []string{`proxy.url "ftp://proxy:21" must use the http, https or socks5 scheme`}},
+		{func(C *Config) { C.Transport.DialTimeoutSeconds = -1 }, []string{"transport.dial_timeout_seconds must be at least 0, got -1"}},
+		{func(C *Config) { C.unknown = []string{"settings.foo"} }, []string{`unknown key "settings.foo"`}},
+		{func(C *Config) {
+			C.Mode = ""
+			C.Log = ""
+		}, []string{"mode is required", "log is required"}},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			C := TestConfig
+			C.Settings.Reporting.ReportFrequencySeconds = 10
+			test.modify(&C)
+
+			err := C.Validate()
+			if test.expected == nil {
+				if err != nil {
+					t.Fatalf("expected no error, got '%s'", err)
+				}
+				return
+			}
+
+			problems, ok := err.(ValidationError)
+			if !ok || len(problems) != len(test.expected) {
+				t.Fatalf("expected %d problems, got '%v'", len(test.expected), err)
+			}
+			for index, expected := range test.expected {
+				if !strings.Contains(problems[index], expected) {
+					t.Fatalf("expected '%s' in '%s'", expected, problems[index])
+				}
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		json     string
+		expected string
+	}{
+		{`{"mode": "reporter", "settings": {"reporting": {"collect_frequency_seconds": 5}}}`, "[]"},
+		{`{"Mode": "reporter", "identification": {"ID": "test-id"}}`, "[]"},
+		{`{"modes": "reporter", "settings": {"disk": {"include_partitions": true}}}`, "[modes settings.disk.include_partitions]"},
+		{`{"reporting": {"collect_frequency_seconds": 5}}`, "[reporting]"},
+		{`{"settings": {"collectors": {"cpu": {"enabled": true, "interval": 5, "options": {"any": 1}}}}}`, "[settings.collectors.cpu.interval]"},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			file, err := ioutil.TempFile("", "sse-config")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = os.Remove(file.Name())
+			}()
+
+			_, _ = file.WriteString(test.json)
+			_ = file.Close()
+
+			C, err := Parse(file.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := fmt.Sprint(C.unknown); actual != test.expected {
+				t.Fatalf("expected '%s', got '%s'", test.expected, actual)
+			}
+		})
+	}
+}
@@ -7,12 +7,13 @@ import (
 	"github.com/jsanc623/ServerStatusEmitter/config"
 	error2 "github.com/jsanc623/ServerStatusEmitter/sphlog"
 	"io/ioutil"
-	"net/http"
+	"log"
+	"net"
 	"regexp"
+	"strings"
+	"time"
 )
 
-var Conf config.Config
-
 type Status struct {
 	Status string
 }
@@ -22,7 +23,7 @@ func CheckStatus(uri string) error {
 	var err error
 	var S Status
 
-	resp, err := http.Get(uri)
+	resp, err := MothershipClient(config.Current().RequestTimeout()).Get(uri)
 	if err != nil {
 		error2.LogError(err)
 		return err
@@ -33,7 +34,7 @@ func CheckStatus(uri string) error {
 	}()
 
 	body, err := ioutil.ReadAll(resp.Body)
-	jsonUnmarshalErr := json.Unmarshal(body, S)
+	jsonUnmarshalErr := json.Unmarshal(body, &S)
 	if err == nil && jsonUnmarshalErr == nil && S.Status == "ok" {
 		return nil
 	}
@@ -44,15 +45,19 @@ func CheckStatus(uri string) error {
 }
 
 // GetServerExternalIPAddress gets the server external IP address (public IP address) and returns it.
-// If Conf.Settings.System.IPAddress is set, it'll return that instead.
+// If the configured Settings.System.IPAddress is set, it'll return that instead.
 // It returns an empty string and an sphlog if it encounters an sphlog.
 func GetServerExternalIPAddress() (string, error) {
-	if Conf.Settings.System.IPAddress != "" {
-		return Conf.Settings.System.IPAddress, nil
+	if ipAddress := config.Current().Settings.System.IPAddress; ipAddress != "" {
+		return ipAddress, nil
 	}
 
-	// Create the default consensus, using the default configuration and no sphlog.
-	consensus := externalip.DefaultConsensus(nil, nil)
+	// Create the consensus of the default sources, sending their requests
+	// through the shared Transport, using the default configuration and no sphlog.
+	consensus := externalip.NewConsensus(nil, nil)
+	for _, source := range ipSources {
+		consensus.AddVoter(source.url, source.weight)
+	}
 
 	// Get your IP, which is never <nil> when err is <nil>.
 	ip, err := consensus.ExternalIP()
@@ -63,6 +68,51 @@ func GetServerExternalIPAddress() (string, error) {
 	return TrimSpaceNewlineInString(ip.String()), nil // return IPv4/IPv6 in string format
 }
 
+// ipSources are the default sources of go-external-ip, TLS-protected
+// providers get more power compared to plain-text providers.
+var ipSources = []struct {
+	url    ipSource
+	weight uint
+}{
+	{"https://icanhazip.com/", 3},
+	{"https://myexternalip.com/raw", 3},
+	{"http://ifconfig.io/ip", 1},
+	{"http://checkip.amazonaws.com/", 1},
+	{"http://ident.me/", 1},
+	{"http://whatismyip.akamai.com/", 1},
+	{"http://tnx.nl/ip", 1},
+	{"http://myip.dnsomatic.com/", 1},
+	{"http://diagnostic.opendns.com/myip", 1},
+}
+
+// ipSource is an externalip.Source reading the external IP address from a
+// URL, like externalip.HTTPSource does, but through the shared Transport so
+// the proxy and TLS settings apply.
+type ipSource string
+
+// IP returns the external IP address the source answers with
+func (ipSource ipSource) IP(timeout time.Duration, logger *log.Logger) (net.IP, error) {
+	resp, err := Client(timeout).Get(string(ipSource))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := strings.TrimSpace(string(body))
+	ip := net.ParseIP(raw)
+	if ip == nil {
+		return nil, externalip.InvalidIPError(raw)
+	}
+	return ip, nil
+}
+
 // TrimSpaceNewlineInString removes newlines
 func TrimSpaceNewlineInString(s string) string {
 	re := regexp.MustCompile(` +\r?\n +`)
@@ -0,0 +1,46 @@
+package helper
+
+import (
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIPSource_IP(t *testing.T) {
+	defer config.Store(&config.Config{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("203.0.113.7\n"))
+	}))
+	defer server.Close()
+
+	// the requests go through the shared Transport, which fails with its configuration
+	tests := []struct {
+		minVersion string
+		expected   string
+	}{
+		{"", "203.0.113.7"},
+		{"2.0", `tls.min_version "2.0" is not a known TLS version`},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			var conf config.Config
+			conf.TLS.MinVersion = test.minVersion
+			config.Store(&conf)
+
+			ip, err := ipSource(server.URL).IP(time.Second, nil)
+			actual := fmt.Sprint(ip)
+			if err != nil {
+				actual = err.Error()
+			}
+			if !strings.Contains(actual, test.expected) {
+				t.Fatalf("expected '%s', got '%s'", test.expected, actual)
+			}
+		})
+	}
+}
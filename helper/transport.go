@@ -0,0 +1,295 @@
+package helper
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/config"
+	error2 "github.com/jsanc623/ServerStatusEmitter/sphlog"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// transportCache is a transport and what it was built from
+type transportCache struct {
+	transport http.RoundTripper
+
+	// conf is the configuration the transport was built from
+	conf *config.Config
+
+	// files are the modification times of the TLS files it read
+	files string
+}
+
+var (
+	transportMutex sync.Mutex
+
+	// shared is the transport of the requests to other destinations than
+	// the mothership, mothership the one of the requests to the mothership
+	shared, mothership transportCache
+)
+
+// Client returns an HTTP client bounded by timeout, using the shared Transport
+func Client(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: Transport(), Timeout: timeout}
+}
+
+// MothershipClient returns an HTTP client bounded by timeout, using the MothershipTransport
+func MothershipClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: MothershipTransport(), Timeout: timeout}
+}
+
+// Transport returns the transport shared by the outbound HTTP requests to
+// other destinations than the mothership, configured by the proxy and
+// transport sections of the configuration and by tls.min_version.
+func Transport() http.RoundTripper {
+	return cachedTransport(&shared, false)
+}
+
+// MothershipTransport is the Transport of the requests to the mothership,
+// which applies the whole tls section of the configuration. It is rebuilt
+// when the section changes, and when one of its files is modified.
+func MothershipTransport() http.RoundTripper {
+	return cachedTransport(&mothership, true)
+}
+
+// cachedTransport returns the transport of cache, rebuilt when the sections
+// it is configured by changed. When they cannot be applied, such as when a
+// certificate cannot be read, every request fails with the reason.
+func cachedTransport(cache *transportCache, withTLS bool) http.RoundTripper {
+	conf := config.Current()
+	var files string
+	if withTLS {
+		files = tlsFiles(conf)
+	}
+
+	transportMutex.Lock()
+	defer transportMutex.Unlock()
+
+	if cache.transport != nil && cache.conf.TLS == conf.TLS && cache.conf.Transport == conf.Transport &&
+		reflect.DeepEqual(cache.conf.Proxy, conf.Proxy) && cache.files == files {
+		return cache.transport
+	}
+
+	if previous, ok := cache.transport.(*http.Transport); ok {
+		previous.CloseIdleConnections()
+	}
+
+	cache.conf, cache.files = conf, files
+	tlsConfig, err := sharedTLSConfig(conf)
+	if withTLS {
+		tlsConfig, err = TLSConfig(conf)
+	}
+	if err == nil {
+		var proxy func(*http.Request) (*url.URL, error)
+		if proxy, err = Proxy(conf); err == nil {
+			cache.transport = newTransport(conf, tlsConfig, proxy)
+			return cache.transport
+		}
+	}
+
+	error2.LogError(err)
+	cache.transport = failingTransport{err}
+	return cache.transport
+}
+
+// tlsFiles returns the modification times of the files of the tls section of conf
+func tlsFiles(conf *config.Config) string {
+	var times []string
+	for _, file := range []string{conf.TLS.CAFile, conf.TLS.CertFile, conf.TLS.KeyFile} {
+		if file == "" {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil {
+			times = append(times, info.ModTime().String())
+		} else {
+			times = append(times, err.Error())
+		}
+	}
+	return strings.Join(times, ",")
+}
+
+// newTransport returns a transport applying the TLS configuration, the
+// proxy, and the limits and timeouts of the transport section of conf.
+// The limits and timeouts left at 0 keep the defaults of net/http.
+func newTransport(conf *config.Config, tlsConfig *tls.Config, proxy func(*http.Request) (*url.URL, error)) *http.Transport {
+	settings := conf.Transport
+	seconds := func(value int) time.Duration { return time.Duration(value) * time.Second }
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = tlsConfig
+	t.Proxy = proxy
+	t.MaxConnsPerHost = settings.MaxConnsPerHost
+	t.ResponseHeaderTimeout = seconds(settings.ResponseHeaderTimeoutSeconds)
+
+	if settings.DialTimeoutSeconds > 0 {
+		dialer := &net.Dialer{Timeout: seconds(settings.DialTimeoutSeconds), KeepAlive: 30 * time.Second}
+		t.DialContext = dialer.DialContext
+	}
+	if settings.MaxIdleConns > 0 {
+		t.MaxIdleConns = settings.MaxIdleConns
+	}
+	if settings.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = settings.MaxIdleConnsPerHost
+	}
+	if settings.IdleConnTimeoutSeconds > 0 {
+		t.IdleConnTimeout = seconds(settings.IdleConnTimeoutSeconds)
+	}
+	if settings.TLSHandshakeTimeoutSeconds > 0 {
+		t.TLSHandshakeTimeout = seconds(settings.TLSHandshakeTimeoutSeconds)
+	}
+
+	return t
+}
+
+// Proxy returns the proxy selection of the proxy section of conf: the
+// configured proxy, with its credentials, for every host not listed in
+// no_proxy, or the proxy of the environment when none is configured.
+func Proxy(conf *config.Config) (func(*http.Request) (*url.URL, error), error) {
+	if conf.Proxy.URL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	proxyURL, err := url.Parse(conf.Proxy.URL)
+	if err != nil {
+		return nil, fmt.Errorf("proxy.url: %s", err)
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("proxy.url: %q has no host", conf.Proxy.URL)
+	}
+	if conf.Proxy.Username != "" {
+		proxyURL.User = url.UserPassword(conf.Proxy.Username, conf.Proxy.Password)
+	}
+
+	noProxy := conf.Proxy.NoProxy
+	return func(req *http.Request) (*url.URL, error) {
+		if bypassProxy(req.URL, noProxy) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}, nil
+}
+
+// bypassProxy tells whether a request to target skips the proxy. The
+// entries of noProxy are host names, matching their subdomains too, IP
+// addresses, CIDR ranges, or * for every host. Host names and IP addresses
+// may carry a port, matching the requests to that port only.
+func bypassProxy(target *url.URL, noProxy []string) bool {
+	host := strings.ToLower(target.Hostname())
+	port := target.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[target.Scheme]
+	}
+	ip := net.ParseIP(host)
+
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return true
+		}
+
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		if entryHost, entryPort, err := net.SplitHostPort(entry); err == nil {
+			if entryPort != port {
+				continue
+			}
+			entry = entryHost
+		}
+		entry = strings.Trim(entry, "[]")
+
+		if entryIP := net.ParseIP(entry); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+
+		entry = strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sharedTLSConfig returns the client TLS configuration of the requests to
+// other destinations than the mothership, which only applies tls.min_version
+func sharedTLSConfig(conf *config.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if conf.TLS.MinVersion != "" {
+		version, known := tlsVersions[conf.TLS.MinVersion]
+		if !known {
+			return nil, fmt.Errorf("tls.min_version %q is not a known TLS version", conf.TLS.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	return tlsConfig, nil
+}
+
+// TLSConfig returns the client TLS configuration of the tls section of conf
+func TLSConfig(conf *config.Config) (*tls.Config, error) {
+	tlsConfig, err := sharedTLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.ServerName = conf.TLS.ServerName
+	tlsConfig.InsecureSkipVerify = conf.TLS.InsecureSkipVerify
+
+	if conf.TLS.CAFile != "" {
+		pem, err := ioutil.ReadFile(conf.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls.ca_file: %s", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls.ca_file: no certificate found in %s", conf.TLS.CAFile)
+		}
+	}
+
+	if conf.TLS.CertFile != "" || conf.TLS.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(conf.TLS.CertFile, conf.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls.cert_file and tls.key_file: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+// failingTransport fails every request, rather than sending it without the configured settings
+type failingTransport struct {
+	err error
+}
+
+// RoundTrip returns the error of the transport
+func (failingTransport failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, failingTransport.err
+}
@@ -1,109 +1,341 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"flag"
+	"fmt"
 	"github.com/jsanc623/ServerStatusEmitter/config"
 	"github.com/jsanc623/ServerStatusEmitter/helper"
 	"github.com/jsanc623/ServerStatusEmitter/runner"
 	"github.com/jsanc623/ServerStatusEmitter/sphlog"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
-// Configuration is the configuration instance (loads the above LogFile)
-var Conf config.Config
+// defaultShutdownTimeout bounds the final report when no shutdown timeout is configured
+const defaultShutdownTimeout = 10 * time.Second
 
-func logger() {
-	logger, err := os.OpenFile(Conf.Log, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+// logger sends the global sphlog to the log file, which stays open for the life of the process
+func logger(conf *config.Config) {
+	logger, err := os.OpenFile(conf.Log, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
 	sphlog.LogFatalError(err)
-	defer func() {
-		_ = logger.Close()
-	}()
 	log.SetOutput(logger)
 }
 
-func init() {
-	// Load and parse configuration file
-	Conf.Load()
+func main() {
+	configFile := flag.String("config", "", "path of the configuration file, defaults to $"+config.EnvironmentFile+" or the first of: "+strings.Join(config.SearchPath, ", "))
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-config file] [validate-config [file] | config dump [--format json|yaml|toml] | schema]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
 
-	// Define the global sphlog
-	logger()
+	file, err := config.Locate(*configFile)
+	if err == nil {
+		config.SetFile(file)
+	}
 
-	sphlog.LogInfo("")
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "validate-config":
+			os.Exit(validateConfig(flag.Args()[1:]))
+		case "config":
+			os.Exit(configCommand(flag.Args()[1:]))
+		case "schema":
+			os.Exit(printSchema())
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+			flag.Usage()
+			os.Exit(2)
+		}
+	}
 
-	helper.Conf = Conf
-	runner.Conf = Conf
+	sphlog.LogFatalError(err)
+	run()
 }
 
-func main() {
+// validateConfig parses and validates a configuration file, the located
+// one unless given, and prints every problem found. It returns the exit status.
+func validateConfig(args []string) int {
+	file := config.File()
+	if len(args) > 0 {
+		file = args[0]
+	}
+
+	conf, err := config.Parse(file)
+	if err == nil {
+		err = conf.Validate()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, file+": "+err.Error())
+		return 1
+	}
+
+	fmt.Println(file + ": configuration is valid")
+	return 0
+}
+
+// configCommand runs the config subcommands. It returns the exit status.
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "dump" {
+		fmt.Fprintln(os.Stderr, "usage: config dump [--format json|yaml|toml]")
+		return 2
+	}
+
+	dump := flag.NewFlagSet("config dump", flag.ContinueOnError)
+	format := dump.String("format", config.FormatJSON, "output format: "+strings.Join(config.Formats, ", "))
+	if err := dump.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	return dumpConfig(*format)
+}
+
+// dumpConfig prints the effective configuration, the configuration file
+// merged with the environment overrides, with its secrets redacted.
+// It returns the exit status.
+func dumpConfig(format string) int {
+	conf, err := config.Parse(config.File())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	out, err := conf.Dump(format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	_, _ = os.Stdout.Write(out)
+	return 0
+}
+
+// printSchema prints the JSON Schema of the snapshots. It returns the exit status.
+func printSchema() int {
+	schema, err := runner.JSONSchema()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	_, _ = os.Stdout.Write(schema)
+	return 0
+}
+
+// run starts the emitter and reports to the mothership until it is asked to stop
+func run() {
 	var err error
 	var server runner.Server
 
-	err = helper.CheckStatus(Conf.GetStatusURL())
+	// Load and parse configuration file, then make it the live configuration
+	conf := &config.Config{}
+	conf.Load()
+	config.Store(conf)
+
+	// Define the global sphlog
+	logger(conf)
+
+	sphlog.LogInfo("")
+
+	err = helper.CheckStatus(conf.GetStatusURL())
 	if err != nil {
 		sphlog.LogFatalError(errors.New("mothership unreachable - check your configuration"))
 	}
 
 	// Perform system initialization
-	Conf.Settings.System.IPAddress, Conf.Settings.System.Hostname, err = server.Initialize()
+	ipAddress, hostname, err := server.Initialize()
 	sphlog.LogError(err)
 
 	// Perform registration
 	_, err = runner.Register(map[string]interface{}{
-		"mothership_url":    Conf.Mothership,
-		"register_url":      Conf.GetRegisterURL(),
+		"mothership_url":    conf.Mothership,
+		"register_url":      conf.GetRegisterURL(),
 		"version":           config.Version,
-		"collect_frequency": Conf.Settings.Reporting.CollectFrequencySeconds,
-		"report_frequency":  Conf.Settings.Reporting.ReportFrequencySeconds,
-		"hostname":          Conf.Settings.System.Hostname,
-		"ip_address":        Conf.Settings.System.IPAddress,
-	}, Conf.GetRegisterURL())
+		"collect_frequency": conf.Settings.Reporting.CollectFrequencySeconds,
+		"report_frequency":  conf.Settings.Reporting.ReportFrequencySeconds,
+		"hostname":          hostname,
+		"ip_address":        ipAddress,
+	}, conf.GetRegisterURL())
 
 	sphlog.LogError(err)
 
 	// Set up our collector
-	var counter int
-	var snapshot runner.Snapshot
 	var cache = runner.Cache{
-		ID:           Conf.Identification.ID,
-		Key:          Conf.Identification.Key,
-		Organization: Conf.Identification.Organization,
-		Group:        Conf.Identification.Group,
-		Entity:       Conf.Identification.Entity,
-		Version:      config.Version,
-		Server:       &server,
+		Server: &server,
 	}
+	cache.Identify(conf)
 
-	ticker := time.NewTicker(time.Duration(Conf.Reporting.CollectFrequencySeconds) * time.Second)
+	// Set up the sinks the cache is reported to, each spooling the batches it could not receive
+	outputs, err := runner.NewOutputs(conf)
+	sphlog.LogFatalError(err)
+
+	// Every collector runs at its own interval, the scheduler merges them into snapshots
+	scheduler := runner.NewScheduler()
+	ticker := time.NewTicker(scheduler.Tick)
+	exporter := servePrometheus(conf, nil)
+	reporter := time.NewTicker(reportFrequency(conf))
 	death := make(chan os.Signal, 1)
-	signal.Notify(death, os.Interrupt, os.Kill)
-
-	go func() {
-		for {
-			select {
-			case <-ticker.C: // send the updated time back via to the channel
-				// reset the snapshot to an empty struct
-				snapshot = runner.Snapshot{}
-				snapshot.Collector()
-
-				// fill in the Snapshot struct and add to the cache
-				cache.Node = append(cache.Node, &snapshot)
-				counter++
-
-				if counter > 0 && (counter%Conf.Reporting.ReportFrequencySeconds) == 0 {
-					cache.Sender(Conf.GetCollectorURL())
-					cache.Node = nil // Clear the Node Cache
-					counter = 0
-				}
-			case <-death:
-				sphlog.LogInfo("chan died")
-				return
+	signal.Notify(death, os.Interrupt, syscall.SIGTERM)
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	// The snapshots are collected aside, so that a signal is handled without
+	// waiting for the collectors. A tick is skipped while one is collected.
+	collecting, cancelCollecting := context.WithCancel(context.Background())
+	snapshots := make(chan *runner.Snapshot, 1)
+	inFlight := false
+
+	// The cache is reported aside as well, each report bounded by the report
+	// frequency. The snapshots collected meanwhile wait for the next report.
+	reporting, cancelReporting := context.WithCancel(context.Background())
+	reported := make(chan error, 1)
+	reportInFlight := false
+
+	for {
+		select {
+		case now := <-ticker.C:
+			// collect the collectors which are due
+			if inFlight {
+				continue
+			}
+			inFlight = true
+			go func(scheduler *runner.Scheduler) {
+				snapshots <- scheduler.Snapshot(collecting, now)
+			}(scheduler)
+		case snapshot := <-snapshots:
+			// add the snapshot to the cache
+			inFlight = false
+			if snapshot != nil {
+				cache.Node = append(cache.Node, snapshot)
 			}
+		case <-reporter.C:
+			if reportInFlight {
+				continue
+			}
+			reportInFlight = true
+			go func(report runner.Cache, outputs []*runner.Output, timeout time.Duration) {
+				ctx, cancel := context.WithTimeout(reporting, timeout)
+				defer cancel()
+				reported <- report.Deliver(ctx, outputs)
+			}(cache, outputs, reportFrequency(conf))
+			cache.Node = nil
+		case err := <-reported:
+			reportInFlight = false
+			sphlog.LogError(err)
+		case <-hangup:
+			previous := conf
+			if conf = reload(); conf == previous {
+				continue
+			}
+
+			// The cache is kept, only its identification and where it is reported to may change
+			cache.Identify(conf)
+			if reconfigured, err := runner.NewOutputs(conf); err != nil {
+				sphlog.LogError(err)
+				sphlog.LogWarn("keeping the previous sinks")
+			} else {
+				outputs = reconfigured
+			}
+
+			rescheduled := runner.NewScheduler()
+			if rescheduled.Tick != scheduler.Tick {
+				ticker.Stop()
+				ticker = time.NewTicker(rescheduled.Tick)
+			}
+			scheduler = rescheduled
+
+			if conf.Settings.Prometheus != previous.Settings.Prometheus {
+				exporter = servePrometheus(conf, exporter)
+			}
+
+			if reportFrequency(conf) != reportFrequency(previous) {
+				reporter.Stop()
+				reporter = time.NewTicker(reportFrequency(conf))
+			}
+		case sig := <-death:
+			ticker.Stop()
+			reporter.Stop()
+			cancelCollecting()
+
+			// the report in flight gives up, spooling what it did not deliver
+			cancelReporting()
+			if reportInFlight {
+				sphlog.LogError(<-reported)
+			}
+			os.Exit(shutdown(sig, &cache, outputs))
 		}
-	}()
+	}
+}
 
-	return
+// reload parses and validates the configuration file again and makes
+// it the live configuration. The previous configuration is kept, and
+// returned, if the file cannot be loaded or is not valid.
+func reload() *config.Config {
+	conf, err := config.Parse(config.File())
+	if err == nil {
+		err = conf.Validate()
+	}
+	if err != nil {
+		sphlog.LogError(err)
+		sphlog.LogWarn("configuration reload failed, keeping the previous configuration")
+		return config.Current()
+	}
+
+	config.Store(conf)
+	sphlog.LogInfo("configuration reloaded from " + config.File())
+	return conf
+}
+
+// servePrometheus stops the running exporter, if any, and starts
+// the one configured in conf. It returns nil if it is disabled.
+func servePrometheus(conf *config.Config, running *http.Server) *http.Server {
+	if running != nil {
+		sphlog.LogError(running.Close())
+	}
+
+	if !conf.Settings.Prometheus.Enabled {
+		return nil
+	}
+
+	exporter, err := runner.ServePrometheus()
+	sphlog.LogError(err)
+
+	return exporter
+}
+
+// reportFrequency returns how often the cache is reported to the mothership
+func reportFrequency(conf *config.Config) time.Duration {
+	return time.Duration(conf.Settings.Reporting.ReportFrequencySeconds) * time.Second
+}
+
+// shutdown performs a final report of the cache within the shutdown
+// timeout, spooling it for the sinks which cannot be reached in time.
+// It returns the exit status: 0 unless the cached snapshots were lost.
+func shutdown(sig os.Signal, cache *runner.Cache, outputs []*runner.Output) int {
+	sphlog.LogInfo("received " + sig.String() + ", flushing cache before exiting")
+
+	conf := config.Current()
+	timeout := time.Duration(conf.Settings.Reporting.ShutdownTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := cache.Deliver(ctx, outputs); err != nil {
+		sphlog.LogError(err)
+		return 1
+	}
 
+	sphlog.LogInfo("shutdown complete")
+	return 0
 }
@@ -0,0 +1,178 @@
+package runner
+
+import (
+	"github.com/jsanc623/ServerStatusEmitter/collector"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterTransformer("aggregated", func(cache *Cache) interface{} { return Aggregate(cache) })
+}
+
+// AggregatedCache is a Cache whose snapshots are rolled into a Summary,
+// so a batch carries one summary per metric instead of one value per
+// snapshot, and the static information once. It is identified under the
+// keys of the RawCache.
+type AggregatedCache struct {
+	Summary      *Summary `json:"summary"`
+	Server       *Server  `json:"server"`
+	ID           string   `json:"account_id"`
+	Version      string   `json:"version"`
+	Organization string   `json:"organization_name"`
+	Group        string   `json:"group,omitempty"`
+	Entity       string   `json:"machine_nickname"`
+}
+
+// Summary describes the snapshots of a batch, from the first one to the last one
+type Summary struct {
+	SchemaVersion int              `json:"schema_version"`
+	From          time.Time        `json:"from"`
+	To            time.Time        `json:"to"`
+	Snapshots     int              `json:"snapshots"`
+	Static        Static           `json:"static"`
+	Metrics       []MetricSummary  `json:"metrics"`
+	Errors        []CollectorError `json:"errors,omitempty"`
+}
+
+// Static is the information which rarely changes, as found in the latest snapshot holding it
+type Static struct {
+	CPUInfo       []collector.CPUInfoStat       `json:"cpu_info_stat"`
+	HostInfo      *collector.HostInfoStat       `json:"host_info"`
+	DiskPartition []collector.DiskPartitionStat `json:"disk_partition_stat"`
+	NetInterface  []collector.NetInterfaceStat  `json:"interface"`
+	Users         []collector.UserStat          `json:"users"`
+}
+
+// MetricSummary rolls the values a metric took over a batch. Counters
+// are summarized too, their last value is usually the one of interest.
+type MetricSummary struct {
+	Measurement string            `json:"measurement"`
+	Field       string            `json:"field"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Counter     bool              `json:"counter"`
+	Count       int               `json:"count"`
+	Min         float64           `json:"min"`
+	Max         float64           `json:"max"`
+	Mean        float64           `json:"mean"`
+	P95         float64           `json:"p95"`
+	Last        float64           `json:"last"`
+}
+
+// Aggregate returns the Cache with its snapshots rolled into a Summary
+func Aggregate(cache *Cache) *AggregatedCache {
+	return &AggregatedCache{
+		Summary:      summarize(cache.Node),
+		Server:       cache.Server,
+		ID:           cache.ID,
+		Version:      cache.Version,
+		Organization: cache.Organization,
+		Group:        cache.Group,
+		Entity:       cache.Entity,
+	}
+}
+
+// summarize rolls the metrics of the snapshots, in order, into a Summary.
+// Metrics are listed in the order they first appear in.
+func summarize(snapshots []*Snapshot) *Summary {
+	summary := &Summary{SchemaVersion: SchemaVersion, Snapshots: len(snapshots), Metrics: make([]MetricSummary, 0)}
+
+	var keys []string
+	metrics := make(map[string]collector.Metric)
+	values := make(map[string][]float64)
+	for index, snapshot := range snapshots {
+		if index == 0 {
+			summary.From = snapshot.Time
+		}
+		summary.To = snapshot.Time
+		summary.Errors = append(summary.Errors, snapshot.Errors...)
+		summary.Static.update(snapshot)
+
+		for _, metric := range snapshot.FreshMetrics() {
+			key := seriesKey(metric)
+			if _, exists := metrics[key]; !exists {
+				keys = append(keys, key)
+				metrics[key] = metric
+			}
+			values[key] = append(values[key], metric.Value)
+		}
+	}
+
+	for _, key := range keys {
+		metric := metrics[key]
+		summary.Metrics = append(summary.Metrics, summarizeValues(metric, values[key]))
+	}
+
+	return summary
+}
+
+// update keeps the static information of a snapshot, over the one of the snapshots before it
+func (Static *Static) update(snapshot *Snapshot) {
+	if snapshot.CPU != nil && snapshot.CPU.Info != nil {
+		Static.CPUInfo = snapshot.CPU.Info
+	}
+	if snapshot.Disks != nil && snapshot.Disks.DiskPartition != nil {
+		Static.DiskPartition = snapshot.Disks.DiskPartition
+	}
+	if snapshot.Network != nil && snapshot.Network.NetInterface != nil {
+		Static.NetInterface = snapshot.Network.NetInterface
+	}
+	if snapshot.System != nil {
+		if snapshot.System.HostInfo != nil {
+			Static.HostInfo = snapshot.System.HostInfo
+		}
+		if snapshot.System.Users != nil {
+			Static.Users = snapshot.System.Users
+		}
+	}
+}
+
+// summarizeValues returns the summary of the values a metric took, in order
+func summarizeValues(metric collector.Metric, values []float64) MetricSummary {
+	summary := MetricSummary{
+		Measurement: metric.Measurement,
+		Field:       metric.Field,
+		Tags:        metric.Tags,
+		Counter:     metric.Counter,
+		Count:       len(values),
+		Min:         math.Inf(1),
+		Max:         math.Inf(-1),
+		Last:        values[len(values)-1],
+	}
+
+	var sum float64
+	for _, value := range values {
+		summary.Min = math.Min(summary.Min, value)
+		summary.Max = math.Max(summary.Max, value)
+		sum += value
+	}
+	summary.Mean = sum / float64(len(values))
+	summary.P95 = percentile(values, 95)
+
+	return summary
+}
+
+// percentile returns the nearest-rank percentile p of values, which must not be empty
+func percentile(values []float64, p float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// seriesKey tells apart the metrics of different measurements, fields and tags
+func seriesKey(metric collector.Metric) string {
+	tags := make([]string, 0, len(metric.Tags))
+	for key, value := range metric.Tags {
+		tags = append(tags, key+"="+value)
+	}
+	sort.Strings(tags)
+
+	return metric.Measurement + "." + metric.Field + "{" + strings.Join(tags, ",") + "}"
+}
@@ -0,0 +1,102 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/collector"
+	"testing"
+	"time"
+)
+
+func TestAggregate(t *testing.T) {
+	start := time.Unix(1500000000, 0).UTC()
+	hostInfo := &collector.HostInfoStat{Hostname: "web-01"}
+
+	var snapshots []*Snapshot
+	for index := 0; index < 20; index++ {
+		snapshot := &Snapshot{
+			Custom: map[string]collector.Collector{"test-metrics": &metricsCollector{[]collector.Metric{
+				{Measurement: "mem", Field: "used_percent", Value: float64(index + 1)},
+				{Measurement: "cpu", Field: "user", Tags: map[string]string{"cpu": "cpu0"}, Value: float64(100 + index), Counter: true},
+			}}},
+			Time: start.Add(time.Duration(index) * time.Second),
+		}
+		if index == 5 {
+			snapshot.System = &collector.System{HostInfo: hostInfo}
+			snapshot.Errors = []CollectorError{{Collector: "disks", Error: "timeout", TimedOut: true}}
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	// a snapshot merging the result of an earlier run does not count it again
+	stale := *snapshots[19]
+	stale.CollectedAt = map[string]time.Time{"test-metrics": stale.Time}
+	stale.Time = start.Add(20 * time.Second)
+	snapshots = append(snapshots, &stale)
+
+	summary := Aggregate(&Cache{Node: snapshots, Entity: "entity"}).Summary
+	tests := []struct {
+		actual   interface{}
+		expected interface{}
+	}{
+		{summary.Snapshots, 21},
+		{summary.From, start},
+		{summary.To, start.Add(20 * time.Second)},
+		{summary.Static.HostInfo, hostInfo},
+		{len(summary.Errors), 1},
+		{summary.Metrics[0], MetricSummary{Measurement: "mem", Field: "used_percent", Count: 20, Min: 1, Max: 20, Mean: 10.5, P95: 19, Last: 20}},
+		{fmt.Sprint(summary.Metrics[1]), "{cpu user map[cpu:cpu0] true 20 100 119 109.5 118 119}"},
+		{len(summary.Metrics), 4}, // with the uptime and process count of the host information
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			if fmt.Sprint(test.actual) != fmt.Sprint(test.expected) {
+				t.Fatalf("expected '%v', got '%v'", test.expected, test.actual)
+			}
+		})
+	}
+}
+
+func TestAggregate_Identification(t *testing.T) {
+	cache := Cache{ID: "id", Version: "1.0", Organization: "org", Group: "group", Entity: "entity"}
+
+	// the aggregated batches are identified as the raw ones
+	keys := func(v interface{}) string {
+		jsonStr, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fields map[string]interface{}
+		if err = json.Unmarshal(jsonStr, &fields); err != nil {
+			t.Fatal(err)
+		}
+		delete(fields, "node")
+		delete(fields, "summary")
+		return fmt.Sprint(fields)
+	}
+	if expected, actual := keys(Raw(&cache)), keys(Aggregate(&cache)); actual != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, actual)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		values   []float64
+		p        float64
+		expected float64
+	}{
+		{[]float64{5}, 95, 5},
+		{[]float64{3, 1, 2}, 95, 3},
+		{[]float64{3, 1, 2}, 50, 2},
+		{[]float64{3, 1, 2}, 0, 1},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			if actual := percentile(test.values, test.p); actual != test.expected {
+				t.Fatalf("expected '%g', got '%g'", test.expected, actual)
+			}
+		})
+	}
+}
@@ -2,57 +2,79 @@ package runner
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/config"
 	error2 "github.com/jsanc623/ServerStatusEmitter/sphlog"
 	"io/ioutil"
 	"net/http"
 	"time"
 )
 
-var (
-	httpClient = &http.Client{}
-)
-
 // Cache struct implements multiple Snapshot structs.
 // This is cleared after it is reported to the mothership.
 // Also includes the program Version and AccountId - the
 // latter of which is gleaned from the configuration.
+// The Key signs the requests and is never sent.
 type Cache struct {
 	Node         []*Snapshot
 	Server       *Server
 	ID           string
 	Version      string
-	Key          string
+	Key          string `json:"-"`
 	Organization string
 	Group        string
 	Entity       string
 }
 
-// Sender sends the data in Cache to the mothership,
-// then clears the Cache struct so that it can accept
-// new data.
-func (Cache *Cache) Sender(collectorURL string) bool {
-	jsonStr, err := json.Marshal(Cache)
+// Identify fills in the identification of the Cache from the configuration
+func (Cache *Cache) Identify(conf *config.Config) {
+	Cache.ID = conf.Identification.ID
+	Cache.Key = conf.Identification.Key
+	Cache.Organization = conf.Identification.Organization
+	Cache.Group = conf.Identification.Group
+	Cache.Entity = conf.Identification.Entity
+	Cache.Version = config.Version
+}
+
+// sendOnce makes a single attempt at posting a JSON body to the mothership.
+// When the attempt fails it tells us whether it is worth retrying and how
+// long the mothership asked us to wait through its Retry-After header.
+// The body is compressed as configured, and sent again with the fallback
+// encoding if the mothership rejects the compressed one.
+func sendOnce(ctx context.Context, collectorURL string, jsonStr []byte) (bool, time.Duration, error) {
+	encoding := reportEncoding(collectorURL)
+	body, err := compress(jsonStr, encoding)
 	if err != nil {
-		error2.LogError(errors.New("malformed JSON in cache.Sender()"))
+		return false, 0, err
 	}
 
-	req, err := http.NewRequest("POST", collectorURL, bytes.NewBuffer(jsonStr))
+	req, err := http.NewRequestWithContext(ctx, "POST", collectorURL, bytes.NewBuffer(body))
 	if err != nil {
-		error2.LogError(err)
-		return false
+		return false, 0, err
 	}
 
-	req.Header.Set("X-Sse-Time", time.Now().UTC().String())
-	req.Header.Set("X-Sse-Mode", Conf.Mode)
-	req.Header.Set("X-Sse-Entity", Conf.Identification.Entity)
+	// X-Sse-Time is the time the request is signed at
+	now := time.Now()
+	req.Header.Set("X-Sse-Time", now.UTC().Format(time.RFC3339))
+	conf := config.Current()
+	req.Header.Set("X-Sse-Mode", conf.Mode)
+	req.Header.Set("X-Sse-Entity", conf.Identification.Entity)
 	req.Header.Set("Content-Type", "application/json")
+	if err = signAt(req, body, conf.Identification.ID, conf.Identification.Key, now); err != nil {
+		return false, 0, err
+	}
+	if encoding != encodingNone {
+		req.Header.Set("Content-Encoding", encoding)
+		error2.LogInfo(fmt.Sprintf("report to %s: %d bytes, %d bytes with %s", collectorURL, len(jsonStr), len(body), encoding))
+	} else {
+		error2.LogInfo(fmt.Sprintf("report to %s: %d bytes", collectorURL, len(jsonStr)))
+	}
 
-	resp, err := httpClient.Do(req)
+	resp, err := client(true).Do(req)
 	if err != nil {
-		error2.LogError(err)
-		return false
+		return true, 0, err
 	}
 
 	defer func() {
@@ -61,9 +83,18 @@ func (Cache *Cache) Sender(collectorURL string) bool {
 
 	readBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		error2.LogError(errors.New("unable to complete request " + string(readBody)))
-		return false
+		return true, 0, errors.New("unable to complete request " + string(readBody))
+	}
+
+	if resp.StatusCode == http.StatusUnsupportedMediaType && encoding != encodingNone {
+		reject(collectorURL, encoding)
+		return sendOnce(ctx, collectorURL, jsonStr)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = fmt.Errorf("mothership responded %s", resp.Status)
+		return retriable(resp.StatusCode), retryAfter(resp.Header.Get("Retry-After"), time.Now()), err
 	}
 
-	return true
+	return false, 0, nil
 }
@@ -0,0 +1,107 @@
+package runner
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/config"
+	error2 "github.com/jsanc623/ServerStatusEmitter/sphlog"
+	"github.com/klauspost/compress/zstd"
+	"sync"
+	"time"
+)
+
+// The settings.reporting.compression values, which are also the Content-Encoding of the reports
+const (
+	encodingNone = "none"
+	encodingGzip = "gzip"
+	encodingZstd = "zstd"
+)
+
+// rejectionPeriod is how long an encoding the mothership rejected is not used,
+// so that a mothership which is upgraded gets compressed reports again
+const rejectionPeriod = time.Hour
+
+var (
+	// rejected holds, by URL, when the encodings the mothership answered
+	// 415 Unsupported Media Type to may be used again
+	rejected      = make(map[string]map[string]time.Time)
+	rejectedMutex sync.Mutex
+)
+
+// reportEncoding returns the Content-Encoding of the reports to url: the
+// configured compression, else gzip if url rejected zstd, else none.
+func reportEncoding(url string) string {
+	rejectedMutex.Lock()
+	defer rejectedMutex.Unlock()
+
+	switch compression := config.Current().Settings.Reporting.Compression; {
+	case compression == encodingZstd && !isRejected(url, encodingZstd, time.Now()):
+		return encodingZstd
+	case (compression == encodingZstd || compression == encodingGzip) && !isRejected(url, encodingGzip, time.Now()):
+		return encodingGzip
+	}
+	return encodingNone
+}
+
+// reject stops compressing the reports to url with an encoding it does not
+// accept, for the rejectionPeriod
+func reject(url string, encoding string) {
+	rejectedMutex.Lock()
+	defer rejectedMutex.Unlock()
+
+	if rejected[url] == nil {
+		rejected[url] = make(map[string]time.Time)
+	}
+	rejected[url][encoding] = time.Now().Add(rejectionPeriod)
+	error2.LogWarn(fmt.Sprintf("%s does not accept %s reports, falling back to %s for %s", url, encoding, fallbackEncoding(url, encoding), rejectionPeriod))
+}
+
+// isRejected tells whether url rejected an encoding less than the rejectionPeriod before now
+func isRejected(url string, encoding string, now time.Time) bool {
+	until, exists := rejected[url][encoding]
+	if exists && !now.Before(until) {
+		delete(rejected[url], encoding)
+		return false
+	}
+	return exists
+}
+
+// fallbackEncoding returns the encoding used once url rejected encoding
+func fallbackEncoding(url string, encoding string) string {
+	if encoding == encodingZstd && !isRejected(url, encodingGzip, time.Now()) {
+		return encodingGzip
+	}
+	return encodingNone
+}
+
+// compress returns the body compressed with the encoding
+func compress(body []byte, encoding string) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	switch encoding {
+	case encodingGzip:
+		writer := gzip.NewWriter(&buffer)
+		if _, err := writer.Write(body); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+	case encodingZstd:
+		writer, err := zstd.NewWriter(&buffer)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = writer.Write(body); err != nil {
+			return nil, err
+		}
+		if err = writer.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return body, nil
+	}
+
+	return buffer.Bytes(), nil
+}
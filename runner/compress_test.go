@@ -0,0 +1,123 @@
+package runner
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/config"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSendOnce_Compression(t *testing.T) {
+	defer config.Store(&config.Config{})
+
+	var encodings []string
+	var received string
+	accepted := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := r.Header.Get("Content-Encoding")
+		if encoding == "" {
+			encoding = "identity"
+		}
+		encodings = append(encodings, encoding)
+		if !accepted[encoding] {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		var body io.Reader = r.Body
+		switch encoding {
+		case "gzip":
+			body, _ = gzip.NewReader(r.Body)
+		case "zstd":
+			decoder, _ := zstd.NewReader(r.Body)
+			defer decoder.Close()
+			body = decoder
+		}
+		readBody, _ := ioutil.ReadAll(body)
+		received = string(readBody)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		compression string
+		accepted    []string
+		expected    string
+	}{
+		{"none", []string{"identity"}, "[identity]"},
+		{"gzip", []string{"identity", "gzip"}, "[gzip]"},
+		{"zstd", []string{"identity", "gzip", "zstd"}, "[zstd]"},
+		{"zstd", []string{"identity", "gzip"}, "[zstd gzip]"},
+		{"zstd", []string{"identity"}, "[zstd gzip identity]"},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			rejected = make(map[string]map[string]time.Time)
+			defer func() {
+				rejected = make(map[string]map[string]time.Time)
+			}()
+
+			conf := testConfig(t)
+			conf.Settings.Reporting.Compression = test.compression
+			config.Store(&conf)
+
+			encodings, received = nil, ""
+			accepted = map[string]bool{}
+			for _, encoding := range test.accepted {
+				accepted[encoding] = true
+			}
+
+			if _, _, err := sendOnce(context.Background(), server.URL, []byte(`{"node":[]}`)); err != nil {
+				t.Fatal(err)
+			}
+			if received != `{"node":[]}` {
+				t.Fatalf("expected '{\"node\":[]}', got '%s'", received)
+			}
+			if fmt.Sprint(encodings) != test.expected {
+				t.Fatalf("expected '%s', got '%v'", test.expected, encodings)
+			}
+		})
+	}
+}
+
+func TestReportEncoding(t *testing.T) {
+	conf := testConfig(t)
+	conf.Settings.Reporting.Compression = encodingZstd
+	config.Store(&conf)
+	defer config.Store(&config.Config{})
+	defer func() {
+		rejected = make(map[string]map[string]time.Time)
+	}()
+
+	// the encodings are rejected by URL, until the rejection period is over
+	rejected = map[string]map[string]time.Time{
+		"https://old.example.com":      {encodingZstd: time.Now().Add(time.Minute)},
+		"https://older.example.com":    {encodingZstd: time.Now().Add(time.Minute), encodingGzip: time.Now().Add(time.Minute)},
+		"https://upgraded.example.com": {encodingZstd: time.Now().Add(-time.Minute)},
+	}
+
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"https://new.example.com", encodingZstd},
+		{"https://old.example.com", encodingGzip},
+		{"https://older.example.com", encodingNone},
+		{"https://upgraded.example.com", encodingZstd},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			if actual := reportEncoding(test.url); actual != test.expected {
+				t.Fatalf("expected '%s', got '%s'", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package runner
+
+import (
+	"github.com/jsanc623/ServerStatusEmitter/collector"
+	"math"
+	"sort"
+	"time"
+)
+
+// The settings.reporting.counters values leaving out the derived rates or the counters
+const (
+	countersRaw     = "raw"
+	countersDerived = "derived"
+)
+
+// Derived holds the rates computed from the cumulative counters of the
+// built-in collectors, over the interval between their last two runs.
+type Derived struct {
+	CPU     []CPUUtilization `json:"cpu,omitempty"`
+	Disks   []DiskRates      `json:"disks,omitempty"`
+	Network []NetworkRates   `json:"network,omitempty"`
+}
+
+// CPUUtilization is the share of the interval a CPU spent in every state,
+// in percent. The cpu-total entry covers every CPU. Busy is the share of
+// the interval spent neither idle nor waiting on IO.
+type CPUUtilization struct {
+	CPU             string  `json:"cpu"`
+	IntervalSeconds float64 `json:"interval_seconds"`
+	Reset           bool    `json:"reset"`
+	User            float64 `json:"user"`
+	System          float64 `json:"system"`
+	Idle            float64 `json:"idle"`
+	Nice            float64 `json:"nice"`
+	Iowait          float64 `json:"iowait"`
+	Irq             float64 `json:"irq"`
+	Softirq         float64 `json:"softirq"`
+	Steal           float64 `json:"steal"`
+	Guest           float64 `json:"guest"`
+	GuestNice       float64 `json:"guest_nice"`
+	Busy            float64 `json:"busy"`
+}
+
+// DiskRates is the throughput of a disk, per second
+type DiskRates struct {
+	Device              string  `json:"device"`
+	IntervalSeconds     float64 `json:"interval_seconds"`
+	Reset               bool    `json:"reset"`
+	ReadBytesPerSecond  float64 `json:"read_bytes_per_second"`
+	WriteBytesPerSecond float64 `json:"write_bytes_per_second"`
+	ReadIOPS            float64 `json:"read_iops"`
+	WriteIOPS           float64 `json:"write_iops"`
+}
+
+// NetworkRates is the traffic of a network interface, per second
+type NetworkRates struct {
+	Interface            string  `json:"interface"`
+	IntervalSeconds      float64 `json:"interval_seconds"`
+	Reset                bool    `json:"reset"`
+	BytesSentPerSecond   float64 `json:"bytes_sent_per_second"`
+	BytesRecvPerSecond   float64 `json:"bytes_recv_per_second"`
+	PacketsSentPerSecond float64 `json:"packets_sent_per_second"`
+	PacketsRecvPerSecond float64 `json:"packets_recv_per_second"`
+	ErrinPerSecond       float64 `json:"errin_per_second"`
+	ErroutPerSecond      float64 `json:"errout_per_second"`
+	DropinPerSecond      float64 `json:"dropin_per_second"`
+	DropoutPerSecond     float64 `json:"dropout_per_second"`
+}
+
+// Metrics returns the derived values as gauges, next to the counters they are derived from
+func (Derived *Derived) Metrics() []collector.Metric {
+	var metrics []collector.Metric
+	add := func(measurement string, tags map[string]string, fields []string, values ...float64) {
+		for index, field := range fields {
+			metrics = append(metrics, collector.Metric{Measurement: measurement, Field: field, Tags: tags, Value: values[index]})
+		}
+	}
+
+	for _, cpu := range Derived.CPU {
+		add("cpu", map[string]string{"cpu": cpu.CPU},
+			[]string{"user_percent", "system_percent", "idle_percent", "nice_percent", "iowait_percent", "irq_percent", "softirq_percent", "steal_percent", "guest_percent", "guest_nice_percent", "busy_percent"},
+			cpu.User, cpu.System, cpu.Idle, cpu.Nice, cpu.Iowait, cpu.Irq, cpu.Softirq, cpu.Steal, cpu.Guest, cpu.GuestNice, cpu.Busy)
+	}
+	for _, disk := range Derived.Disks {
+		add("disk", map[string]string{"device": disk.Device},
+			[]string{"read_bytes_per_second", "write_bytes_per_second", "read_iops", "write_iops"},
+			disk.ReadBytesPerSecond, disk.WriteBytesPerSecond, disk.ReadIOPS, disk.WriteIOPS)
+	}
+	for _, network := range Derived.Network {
+		add("net", map[string]string{"interface": network.Interface},
+			[]string{"bytes_sent_per_second", "bytes_recv_per_second", "packets_sent_per_second", "packets_recv_per_second", "err_in_per_second", "err_out_per_second", "drop_in_per_second", "drop_out_per_second"},
+			network.BytesSentPerSecond, network.BytesRecvPerSecond, network.PacketsSentPerSecond, network.PacketsRecvPerSecond,
+			network.ErrinPerSecond, network.ErroutPerSecond, network.DropinPerSecond, network.DropoutPerSecond)
+	}
+
+	return metrics
+}
+
+// deriver keeps the previous run of every built-in collector with counters,
+// and the values derived from its latest run
+type deriver struct {
+	previous map[string]collector.Collector
+	at       map[string]time.Time
+	derived  Derived
+}
+
+// newDeriver returns a deriver which has seen no run yet
+func newDeriver() *deriver {
+	return &deriver{
+		previous: make(map[string]collector.Collector),
+		at:       make(map[string]time.Time),
+	}
+}
+
+// update derives the values of a new run of a collector, made at now, from its previous run
+func (deriver *deriver) update(c collector.Collector, now time.Time) {
+	name := c.Name()
+	previous, seen := deriver.previous[name]
+	interval := now.Sub(deriver.at[name]).Seconds()
+	deriver.previous[name] = c
+	deriver.at[name] = now
+
+	if !seen || interval <= 0 {
+		return
+	}
+
+	switch c := c.(type) {
+	case *collector.CPU:
+		deriver.derived.CPU = cpuUtilization(previous.(*collector.CPU), c, interval)
+	case *collector.Disks:
+		deriver.derived.Disks = diskRates(previous.(*collector.Disks), c, interval)
+	case *collector.Network:
+		deriver.derived.Network = networkRates(previous.(*collector.Network), c, interval)
+	}
+}
+
+// forget drops the previous run of a collector and the values derived from
+// it, so that they are derived again from its next two successful runs
+func (deriver *deriver) forget(name string) {
+	delete(deriver.previous, name)
+	delete(deriver.at, name)
+
+	switch name {
+	case "cpu":
+		deriver.derived.CPU = nil
+	case "disks":
+		deriver.derived.Disks = nil
+	case "network":
+		deriver.derived.Network = nil
+	}
+}
+
+// snapshot returns a copy of the latest derived values, or nil if there are none yet
+func (deriver *deriver) snapshot() *Derived {
+	if deriver.derived.CPU == nil && deriver.derived.Disks == nil && deriver.derived.Network == nil {
+		return nil
+	}
+	derived := deriver.derived
+	return &derived
+}
+
+// cpuUtilization returns the utilization of every CPU found in both runs,
+// followed by the utilization of all of them as cpu-total. A CPU whose
+// times went backwards was reset, its utilization is then since the reset.
+func cpuUtilization(previous *collector.CPU, current *collector.CPU, interval float64) []CPUUtilization {
+	before := make(map[string]collector.CPUTimeStat, len(previous.Times))
+	for _, times := range previous.Times {
+		before[times.CPU] = times
+	}
+
+	var utilization []CPUUtilization
+	var total collector.CPUTimeStat
+	reset := false
+	for _, times := range current.Times {
+		last, exists := before[times.CPU]
+		if !exists {
+			continue
+		}
+
+		delta, deltaReset := cpuDelta(last, times)
+		if u, ok := cpuPercentages(times.CPU, delta, interval); ok {
+			u.Reset = deltaReset
+			utilization = append(utilization, u)
+		}
+
+		reset = reset || deltaReset
+		total = addCPUTimes(total, delta)
+	}
+
+	if u, ok := cpuPercentages("cpu-total", total, interval); ok && len(utilization) > 0 {
+		u.Reset = reset
+		utilization = append(utilization, u)
+	}
+
+	return utilization
+}
+
+// cpuDelta returns the time a CPU spent in every state between two runs,
+// or since boot if any of its times went backwards
+func cpuDelta(previous collector.CPUTimeStat, current collector.CPUTimeStat) (collector.CPUTimeStat, bool) {
+	delta := collector.CPUTimeStat{
+		User:      current.User - previous.User,
+		System:    current.System - previous.System,
+		Idle:      current.Idle - previous.Idle,
+		Nice:      current.Nice - previous.Nice,
+		Iowait:    current.Iowait - previous.Iowait,
+		Irq:       current.Irq - previous.Irq,
+		Softirq:   current.Softirq - previous.Softirq,
+		Steal:     current.Steal - previous.Steal,
+		Guest:     current.Guest - previous.Guest,
+		GuestNice: current.GuestNice - previous.GuestNice,
+	}
+
+	for _, value := range []float64{delta.User, delta.System, delta.Idle, delta.Nice, delta.Iowait, delta.Irq, delta.Softirq, delta.Steal, delta.Guest, delta.GuestNice} {
+		if value < 0 {
+			return current, true
+		}
+	}
+	return delta, false
+}
+
+// addCPUTimes returns the sum of the times of two CPUs
+func addCPUTimes(a collector.CPUTimeStat, b collector.CPUTimeStat) collector.CPUTimeStat {
+	return collector.CPUTimeStat{
+		User:      a.User + b.User,
+		System:    a.System + b.System,
+		Idle:      a.Idle + b.Idle,
+		Nice:      a.Nice + b.Nice,
+		Iowait:    a.Iowait + b.Iowait,
+		Irq:       a.Irq + b.Irq,
+		Softirq:   a.Softirq + b.Softirq,
+		Steal:     a.Steal + b.Steal,
+		Guest:     a.Guest + b.Guest,
+		GuestNice: a.GuestNice + b.GuestNice,
+	}
+}
+
+// cpuPercentages returns the share of the elapsed CPU time spent in every
+// state. Guest times are already counted in the user and nice times, so
+// they are left out of the elapsed time. It fails if no time elapsed.
+func cpuPercentages(cpu string, delta collector.CPUTimeStat, interval float64) (CPUUtilization, bool) {
+	elapsed := delta.User + delta.System + delta.Idle + delta.Nice + delta.Iowait + delta.Irq + delta.Softirq + delta.Steal
+	if elapsed <= 0 {
+		return CPUUtilization{}, false
+	}
+
+	percent := func(value float64) float64 {
+		return 100 * value / elapsed
+	}
+	return CPUUtilization{
+		CPU:             cpu,
+		IntervalSeconds: interval,
+		User:            percent(delta.User),
+		System:          percent(delta.System),
+		Idle:            percent(delta.Idle),
+		Nice:            percent(delta.Nice),
+		Iowait:          percent(delta.Iowait),
+		Irq:             percent(delta.Irq),
+		Softirq:         percent(delta.Softirq),
+		Steal:           percent(delta.Steal),
+		Guest:           percent(delta.Guest),
+		GuestNice:       percent(delta.GuestNice),
+		Busy:            percent(elapsed - delta.Idle - delta.Iowait),
+	}, true
+}
+
+// diskRates returns the rates of every disk found in both runs, sorted by device
+func diskRates(previous *collector.Disks, current *collector.Disks, interval float64) []DiskRates {
+	devices := make([]string, 0, len(current.DiskIOCounters))
+	for device := range current.DiskIOCounters {
+		if _, exists := previous.DiskIOCounters[device]; exists {
+			devices = append(devices, device)
+		}
+	}
+	sort.Strings(devices)
+
+	var rates []DiskRates
+	for _, device := range devices {
+		last, io := previous.DiskIOCounters[device], current.DiskIOCounters[device]
+		deltas, reset := counterDeltas(
+			[]uint64{last.ReadBytes, last.WriteBytes, last.ReadCount, last.WriteCount},
+			[]uint64{io.ReadBytes, io.WriteBytes, io.ReadCount, io.WriteCount},
+		)
+		rates = append(rates, DiskRates{
+			Device:              device,
+			IntervalSeconds:     interval,
+			Reset:               reset,
+			ReadBytesPerSecond:  deltas[0] / interval,
+			WriteBytesPerSecond: deltas[1] / interval,
+			ReadIOPS:            deltas[2] / interval,
+			WriteIOPS:           deltas[3] / interval,
+		})
+	}
+	return rates
+}
+
+// networkRates returns the rates of every interface found in both runs
+func networkRates(previous *collector.Network, current *collector.Network, interval float64) []NetworkRates {
+	before := make(map[string]collector.NetIOCountersStat, len(previous.NetIOCounters))
+	for _, io := range previous.NetIOCounters {
+		before[io.Name] = io
+	}
+
+	var rates []NetworkRates
+	for _, io := range current.NetIOCounters {
+		last, exists := before[io.Name]
+		if !exists {
+			continue
+		}
+
+		deltas, reset := counterDeltas(
+			[]uint64{last.BytesSent, last.BytesRecv, last.PacketsSent, last.PacketsRecv, last.Errin, last.Errout, last.Dropin, last.Dropout},
+			[]uint64{io.BytesSent, io.BytesRecv, io.PacketsSent, io.PacketsRecv, io.Errin, io.Errout, io.Dropin, io.Dropout},
+		)
+		rates = append(rates, NetworkRates{
+			Interface:            io.Name,
+			IntervalSeconds:      interval,
+			Reset:                reset,
+			BytesSentPerSecond:   deltas[0] / interval,
+			BytesRecvPerSecond:   deltas[1] / interval,
+			PacketsSentPerSecond: deltas[2] / interval,
+			PacketsRecvPerSecond: deltas[3] / interval,
+			ErrinPerSecond:       deltas[4] / interval,
+			ErroutPerSecond:      deltas[5] / interval,
+			DropinPerSecond:      deltas[6] / interval,
+			DropoutPerSecond:     deltas[7] / interval,
+		})
+	}
+	return rates
+}
+
+// counterDeltas returns how much every counter grew between two runs, and
+// whether any of them was reset
+func counterDeltas(previous []uint64, current []uint64) ([]float64, bool) {
+	deltas := make([]float64, len(current))
+	reset := false
+	for index := range current {
+		delta, counterReset := counterDelta(previous[index], current[index])
+		deltas[index] = float64(delta)
+		reset = reset || counterReset
+	}
+	return deltas, reset
+}
+
+// counterDelta returns how much a counter grew from previous to current.
+// A counter which went backwards either wrapped around at 32 bits, when
+// both values fit in 32 bits and the wrapped delta is less than half of
+// that range, or was reset, such as by a reboot, and counted from zero.
+func counterDelta(previous uint64, current uint64) (uint64, bool) {
+	if current >= previous {
+		return current - previous, false
+	}
+
+	if previous <= math.MaxUint32 {
+		if wrapped := current + math.MaxUint32 + 1 - previous; wrapped < 1<<31 {
+			return wrapped, false
+		}
+	}
+	return current, true
+}
@@ -0,0 +1,136 @@
+package runner
+
+import (
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/collector"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		previous uint64
+		current  uint64
+		expected string
+	}{
+		{100, 250, "150 false"},
+		{100, 100, "0 false"},
+		{math.MaxUint32 - 9, 20, "30 false"},             // wrapped around at 32 bits
+		{math.MaxUint32 - 9, 1 << 31, "2147483648 true"}, // too far to be a wrap
+		{math.MaxUint64 - 9, 20, "20 true"},              // 64 bits counters do not wrap in practice
+		{5000, 20, "20 true"},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			delta, reset := counterDelta(test.previous, test.current)
+			if actual := fmt.Sprint(delta, reset); actual != test.expected {
+				t.Fatalf("expected '%s', got '%s'", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestDeriver_Update(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	runs := []struct {
+		cpu     *collector.CPU
+		disks   *collector.Disks
+		network *collector.Network
+	}{
+		{
+			&collector.CPU{Times: []collector.CPUTimeStat{{CPU: "cpu0", User: 10, Idle: 90}, {CPU: "cpu1", User: 50, Idle: 50}}},
+			&collector.Disks{DiskIOCounters: map[string]collector.DiskIOCountersStat{"sda": {ReadBytes: 1000, WriteBytes: 2000, ReadCount: 10, WriteCount: 20}}},
+			&collector.Network{NetIOCounters: []collector.NetIOCountersStat{{Name: "eth0", BytesSent: math.MaxUint32 - 99, BytesRecv: 500, Errin: 4}}},
+		},
+		{
+			&collector.CPU{Times: []collector.CPUTimeStat{{CPU: "cpu0", User: 13, System: 1, Idle: 96}, {CPU: "cpu1", User: 51, Idle: 59}}},
+			&collector.Disks{DiskIOCounters: map[string]collector.DiskIOCountersStat{"sda": {ReadBytes: 21000, WriteBytes: 2000, ReadCount: 30, WriteCount: 20}}},
+			&collector.Network{NetIOCounters: []collector.NetIOCountersStat{{Name: "eth0", BytesSent: 900, BytesRecv: 100, Errin: 4}}},
+		},
+	}
+
+	deriver := newDeriver()
+	for index, run := range runs {
+		now := start.Add(time.Duration(index*10) * time.Second)
+		deriver.update(run.cpu, now)
+		deriver.update(run.disks, now)
+		deriver.update(run.network, now)
+
+		if index == 0 && deriver.snapshot() != nil {
+			t.Fatalf("expected no derived values after a single run, got '%v'", deriver.snapshot())
+		}
+	}
+
+	derived := deriver.snapshot()
+	tests := []struct {
+		actual   interface{}
+		expected string
+	}{
+		{derived.CPU[0], "{cpu0 10 false 30 10 60 0 0 0 0 0 0 0 40}"},
+		{derived.CPU[1], "{cpu1 10 false 10 0 90 0 0 0 0 0 0 0 10}"},
+		{derived.CPU[2], "{cpu-total 10 false 20 5 75 0 0 0 0 0 0 0 25}"},
+		{derived.Disks, "[{sda 10 false 2000 0 2 0}]"},
+		// the bytes sent wrapped around at 32 bits, the bytes received were reset
+		{derived.Network, "[{eth0 10 true 100 10 0 0 0 0 0 0}]"},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			if actual := fmt.Sprint(test.actual); actual != test.expected {
+				t.Fatalf("expected '%s', got '%s'", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSnapshot_DropCounters(t *testing.T) {
+	cpu := &collector.CPU{Times: []collector.CPUTimeStat{{CPU: "cpu0"}}, Count: 1}
+	snapshot := &Snapshot{CPU: cpu}
+	snapshot.dropCounters()
+
+	if snapshot.CPU.Times != nil || snapshot.CPU.Count != 1 {
+		t.Fatalf("expected the CPU times alone to be dropped, got '%v'", snapshot.CPU)
+	}
+	if cpu.Times == nil {
+		t.Fatalf("expected the collector shared with other snapshots to keep its times")
+	}
+}
+
+func TestDeriver_Forget(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	runs := []*collector.CPU{
+		{Times: []collector.CPUTimeStat{{CPU: "cpu0", User: 10, Idle: 90}}},
+		{Times: []collector.CPUTimeStat{{CPU: "cpu0", User: 20, Idle: 180}}},
+		{Times: []collector.CPUTimeStat{{CPU: "cpu0", User: 30, Idle: 270}}},
+	}
+
+	// a failed run drops the rates, which are derived again from the next two runs
+	tests := []struct {
+		cpu      *collector.CPU
+		forget   bool
+		expected bool
+	}{
+		{runs[0], false, false},
+		{runs[1], false, true},
+		{nil, true, false},
+		{runs[2], false, false},
+		{runs[0], false, true},
+	}
+
+	deriver := newDeriver()
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			if test.forget {
+				deriver.forget("cpu")
+			} else {
+				deriver.update(test.cpu, start.Add(time.Duration(i)*10*time.Second))
+			}
+
+			if derived := deriver.snapshot() != nil; derived != test.expected {
+				t.Fatalf("expected derived values '%t', got '%t'", test.expected, derived)
+			}
+		})
+	}
+}
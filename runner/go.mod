@@ -1,3 +1,5 @@
 module github.com/jsanc623/ServerStatusEmitter/runner
 
 go 1.13
+
+require github.com/klauspost/compress v1.18.0
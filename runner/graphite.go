@@ -0,0 +1,226 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/collector"
+	"math"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultGraphiteTemplate = "{organization}.{group}.{entity}.{measurement}.{tags}.{field}"
+	defaultPacketSize       = 1432 // fits in an Ethernet frame
+)
+
+var (
+	graphitePlaceholderRgx = regexp.MustCompile(`\{[a-z_]+\}`)
+	graphiteSegmentRgx     = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+)
+
+func init() {
+	RegisterSink("graphite", func(name string) Sink { return &GraphiteSink{name: name} })
+	RegisterSink("statsd", func(name string) Sink { return &GraphiteSink{name: name, StatsD: true} })
+}
+
+// GraphiteSink writes the metrics of every Snapshot to Graphite in the
+// plaintext protocol, or to StatsD as gauges, over TCP or UDP. Metric
+// paths are built from Template, where {organization}, {group}, {entity},
+// {id}, {hostname}, {measurement} and {field} are replaced by their value,
+// {tags} by the values of the metric tags sorted by key, and any other
+// {name} by the value of the metric tag of that name.
+type GraphiteSink struct {
+	name string
+
+	// StatsD writes StatsD gauges instead of Graphite plaintext lines
+	StatsD bool
+
+	// Address is the host:port of the server
+	Address string
+
+	// Protocol is tcp or udp, defaults to tcp for Graphite and udp for StatsD
+	Protocol string
+
+	// Template builds the path of every metric
+	Template string
+
+	// MaxPacketSize caps the size of every UDP packet
+	MaxPacketSize int
+}
+
+// Name returns the name the sink is configured under
+func (GraphiteSink *GraphiteSink) Name() string {
+	return GraphiteSink.name
+}
+
+// Configure reads the options of the sink: address, protocol, template and max_packet_size
+func (GraphiteSink *GraphiteSink) Configure(options map[string]interface{}) error {
+	protocol := "tcp"
+	if GraphiteSink.StatsD {
+		protocol = "udp"
+	}
+
+	var err error
+	if GraphiteSink.Address, err = collector.StringOption(options, "address", ""); err != nil {
+		return err
+	}
+	if GraphiteSink.Protocol, err = collector.StringOption(options, "protocol", protocol); err != nil {
+		return err
+	}
+	if GraphiteSink.Template, err = collector.StringOption(options, "template", defaultGraphiteTemplate); err != nil {
+		return err
+	}
+	if GraphiteSink.MaxPacketSize, err = collector.IntOption(options, "max_packet_size", defaultPacketSize); err != nil {
+		return err
+	}
+
+	if _, _, err = net.SplitHostPort(GraphiteSink.Address); err != nil {
+		return fmt.Errorf("option \"address\" must be a host:port, got %q", GraphiteSink.Address)
+	}
+	if GraphiteSink.Protocol != "tcp" && GraphiteSink.Protocol != "udp" {
+		return fmt.Errorf("option \"protocol\" must be tcp or udp, got %q", GraphiteSink.Protocol)
+	}
+	if !strings.Contains(GraphiteSink.Template, "{field}") {
+		return errors.New(`option "template" must hold {field}, or every field of a measurement would share a path`)
+	}
+	if GraphiteSink.MaxPacketSize < 1 {
+		return errors.New(`option "max_packet_size" must be at least 1`)
+	}
+
+	return nil
+}
+
+// Send writes the metrics of the Cache over a new connection
+func (GraphiteSink *GraphiteSink) Send(ctx context.Context, cache *Cache) error {
+	var lines []string
+	for _, snapshot := range cache.Node {
+		timestamp := strconv.FormatInt(snapshot.Time.Unix(), 10)
+		for _, metric := range snapshot.FreshMetrics() {
+			// NaN and infinite values are not numbers to Graphite or StatsD
+			if math.IsNaN(metric.Value) || math.IsInf(metric.Value, 0) {
+				continue
+			}
+
+			path := graphitePath(GraphiteSink.Template, cache, metric)
+			value := strconv.FormatFloat(metric.Value, 'f', -1, 64)
+
+			switch {
+			case !GraphiteSink.StatsD:
+				lines = append(lines, path+" "+value+" "+timestamp)
+			case metric.Value < 0:
+				// a signed gauge would be taken as a change of the previous value
+				lines = append(lines, path+":0|g", path+":"+value+"|g")
+			default:
+				lines = append(lines, path+":"+value+"|g")
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	dialer := net.Dialer{Timeout: client(false).Timeout}
+	conn, err := dialer.DialContext(ctx, GraphiteSink.Protocol, GraphiteSink.Address)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	deadline := time.Now().Add(client(false).Timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err = conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+
+	if GraphiteSink.Protocol == "tcp" {
+		_, err = conn.Write([]byte(strings.Join(lines, "\n") + "\n"))
+		return err
+	}
+
+	// Every UDP packet holds whole lines, so a lost packet only loses its own metrics
+	var packet []byte
+	for _, line := range lines {
+		if len(packet) > 0 && len(packet)+len(line)+1 > GraphiteSink.MaxPacketSize {
+			if _, err = conn.Write(packet); err != nil {
+				return err
+			}
+			packet = packet[:0]
+		}
+		packet = append(packet, line+"\n"...)
+	}
+	_, err = conn.Write(packet)
+	return err
+}
+
+// graphitePath returns the path of a metric from the template. Every value
+// is sanitized into a single path segment, and empty segments are dropped.
+// Without {tags} in the template, the values of the tags it does not name
+// are appended, so that the series of a metric never share a path.
+func graphitePath(template string, cache *Cache, metric collector.Metric) string {
+	values := map[string]string{
+		"{organization}": cache.Organization,
+		"{group}":        cache.Group,
+		"{entity}":       cache.Entity,
+		"{id}":           cache.ID,
+		"{measurement}":  metric.Measurement,
+		"{field}":        metric.Field,
+	}
+	if cache.Server != nil {
+		values["{hostname}"] = cache.Server.Hostname
+	}
+
+	keys := make([]string, 0, len(metric.Tags))
+	for key := range metric.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var tags []string
+	for _, key := range keys {
+		tags = append(tags, graphiteSegment(metric.Tags[key]))
+	}
+
+	named := make(map[string]bool)
+	path := graphitePlaceholderRgx.ReplaceAllStringFunc(template, func(placeholder string) string {
+		if placeholder == "{tags}" {
+			return strings.Join(tags, ".")
+		}
+		if value, exists := values[placeholder]; exists {
+			return graphiteSegment(value)
+		}
+		named[strings.Trim(placeholder, "{}")] = true
+		return graphiteSegment(metric.Tags[strings.Trim(placeholder, "{}")])
+	})
+
+	if !strings.Contains(template, "{tags}") {
+		for _, key := range keys {
+			if !named[key] {
+				path += "." + graphiteSegment(metric.Tags[key])
+			}
+		}
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(path, ".") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return strings.Join(segments, ".")
+}
+
+// graphiteSegment turns a value into a single path segment
+func graphiteSegment(value string) string {
+	return graphiteSegmentRgx.ReplaceAllString(value, "_")
+}
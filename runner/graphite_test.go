@@ -0,0 +1,128 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/collector"
+	"io/ioutil"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGraphitePath(t *testing.T) {
+	cache := Cache{ID: "id", Organization: "Acme Inc", Group: "web", Entity: "web-01.example.com", Server: &Server{Hostname: "web-01"}}
+	metric := collector.Metric{Measurement: "disk", Field: "used_percent", Tags: map[string]string{"path": "/var/lib", "device": "sda1"}}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{defaultGraphiteTemplate, "Acme_Inc.web.web-01_example_com.disk.sda1._var_lib.used_percent"},
+		{"servers.{hostname}.{measurement}.{device}.{tags}.{field}", "servers.web-01.disk.sda1.sda1._var_lib.used_percent"},
+		{"servers.{hostname}.{measurement}.{device}.{field}", "servers.web-01.disk.sda1.used_percent._var_lib"},
+		{"{id}.{measurement}.{unknown}.{field}", "id.disk.used_percent.sda1._var_lib"},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			if actual := graphitePath(test.template, &cache, metric); actual != test.expected {
+				t.Fatalf("expected '%s', got '%s'", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestGraphiteSink_Send(t *testing.T) {
+	cache := Cache{
+		Organization: "org",
+		Group:        "group",
+		Entity:       "entity",
+		Node: []*Snapshot{{
+			Custom: map[string]collector.Collector{
+				"test-ok": &testCollector{name: "test-ok"},
+				"test-metrics": &metricsCollector{[]collector.Metric{
+					{Measurement: "test", Field: "nan", Value: math.NaN()},
+					{Measurement: "test", Field: "inf", Value: math.Inf(-1)},
+				}},
+			},
+			Time:   time.Unix(1500000000, 0),
+		}},
+	}
+
+	tests := []struct {
+		kind     string
+		options  map[string]interface{}
+		expected string
+	}{
+		{"graphite", map[string]interface{}{}, "org.group.entity.test.test-ok.a_b_.value 1.5 1500000000\norg.group.entity.test.runs 3 1500000000\n"},
+		{"statsd", map[string]interface{}{"template": "sse.{name}.{field}"}, "sse.test-ok.value.a_b_:1.5|g\nsse.runs:3|g\n"},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			received := make(chan string, 1)
+			var address string
+
+			if test.kind == "graphite" {
+				listener, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer func() {
+					_ = listener.Close()
+				}()
+				address = listener.Addr().String()
+
+				go func() {
+					conn, err := listener.Accept()
+					if err != nil {
+						return
+					}
+					data, _ := ioutil.ReadAll(conn)
+					received <- string(data)
+				}()
+			} else {
+				conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer func() {
+					_ = conn.Close()
+				}()
+				address = conn.LocalAddr().String()
+
+				go func() {
+					packet := make([]byte, defaultPacketSize)
+					n, _, err := conn.ReadFrom(packet)
+					if err != nil {
+						return
+					}
+					received <- string(packet[:n])
+				}()
+			}
+
+			sink, err := NewSink(test.kind, test.kind)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.options["address"] = address
+			if err = sink.Configure(test.options); err != nil {
+				t.Fatal(err)
+			}
+			if err = sink.Send(context.Background(), &cache); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case actual := <-received:
+				if actual != test.expected {
+					t.Fatalf("expected '%s', got '%s'", test.expected, actual)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("expected the metrics to be received")
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package runner
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/collector"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultInfluxBatchSize = 5000
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `)
+	influxTagEscaper         = strings.NewReplacer(`,`, `\,`, ` `, `\ `, `=`, `\=`)
+)
+
+func init() {
+	RegisterSink("influxdb", func(name string) Sink { return &InfluxSink{name: name} })
+}
+
+// InfluxSink writes the metrics of every Snapshot to an InfluxDB v1 or v2
+// HTTP write endpoint in line protocol, in batches of BatchSize lines.
+type InfluxSink struct {
+	name string
+
+	// URL is the base URL of the InfluxDB server, such as http://localhost:8086
+	URL string
+
+	// Version is the InfluxDB write API to use, 1 or 2
+	Version int
+
+	// Database, RetentionPolicy, Username and Password are used by the v1 API
+	Database        string
+	RetentionPolicy string
+	Username        string
+	Password        string
+
+	// Organization, Bucket and Token are used by the v2 API
+	Organization string
+	Bucket       string
+	Token        string
+
+	// BatchSize caps the number of lines sent per request
+	BatchSize int
+
+	// Gzip compresses the requests
+	Gzip bool
+
+	// TLS applies the tls section of the configuration, kept to the mothership otherwise
+	TLS bool
+}
+
+// Name returns the name the sink is configured under
+func (InfluxSink *InfluxSink) Name() string {
+	return InfluxSink.name
+}
+
+// Configure reads the options of the sink: url, version (1 by default), database,
+// retention_policy, username and password for v1, organization, bucket and token
+// for v2, batch_size (5000 by default), gzip (true by default) and tls.
+func (InfluxSink *InfluxSink) Configure(options map[string]interface{}) error {
+	var err error
+	stringOptions := []struct {
+		key   string
+		value *string
+	}{
+		{"url", &InfluxSink.URL},
+		{"database", &InfluxSink.Database},
+		{"retention_policy", &InfluxSink.RetentionPolicy},
+		{"username", &InfluxSink.Username},
+		{"password", &InfluxSink.Password},
+		{"organization", &InfluxSink.Organization},
+		{"bucket", &InfluxSink.Bucket},
+		{"token", &InfluxSink.Token},
+	}
+	for _, option := range stringOptions {
+		if *option.value, err = collector.StringOption(options, option.key, ""); err != nil {
+			return err
+		}
+	}
+
+	if InfluxSink.Version, err = collector.IntOption(options, "version", 1); err != nil {
+		return err
+	}
+	if InfluxSink.BatchSize, err = collector.IntOption(options, "batch_size", defaultInfluxBatchSize); err != nil {
+		return err
+	}
+	if InfluxSink.Gzip, err = collector.BoolOption(options, "gzip", true); err != nil {
+		return err
+	}
+	if InfluxSink.TLS, err = collector.BoolOption(options, "tls", false); err != nil {
+		return err
+	}
+
+	if u, err := url.Parse(InfluxSink.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("option \"url\" must be the URL of the InfluxDB server, got %q", InfluxSink.URL)
+	}
+	if InfluxSink.BatchSize < 1 {
+		return errors.New(`option "batch_size" must be at least 1`)
+	}
+
+	switch InfluxSink.Version {
+	case 1:
+		if InfluxSink.Database == "" {
+			return errors.New(`option "database" is required by InfluxDB v1`)
+		}
+	case 2:
+		if InfluxSink.Organization == "" || InfluxSink.Bucket == "" {
+			return errors.New(`options "organization" and "bucket" are required by InfluxDB v2`)
+		}
+	default:
+		return fmt.Errorf("option \"version\" must be 1 or 2, got %d", InfluxSink.Version)
+	}
+
+	return nil
+}
+
+// Send writes the metrics of the Cache in batches. A batch which fails is
+// retried with the ones before it, which InfluxDB overwrites as they are.
+func (InfluxSink *InfluxSink) Send(ctx context.Context, cache *Cache) error {
+	lines := lineProtocol(cache)
+
+	for start := 0; start < len(lines); start += InfluxSink.BatchSize {
+		end := start + InfluxSink.BatchSize
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		if err := InfluxSink.write(ctx, []byte(strings.Join(lines[start:end], "\n")+"\n")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// write posts a batch of lines to the write endpoint
+func (InfluxSink *InfluxSink) write(ctx context.Context, body []byte) error {
+	if InfluxSink.Gzip {
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		if _, err := writer.Write(body); err != nil {
+			return &SinkError{Err: err}
+		}
+		if err := writer.Close(); err != nil {
+			return &SinkError{Err: err}
+		}
+		body = buffer.Bytes()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", InfluxSink.writeURL(), bytes.NewReader(body))
+	if err != nil {
+		return &SinkError{Err: err}
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if InfluxSink.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if InfluxSink.Version == 2 && InfluxSink.Token != "" {
+		req.Header.Set("Authorization", "Token "+InfluxSink.Token)
+	} else if InfluxSink.Version == 1 && InfluxSink.Username != "" {
+		req.SetBasicAuth(InfluxSink.Username, InfluxSink.Password)
+	}
+
+	resp, err := client(InfluxSink.TLS).Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	readBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &SinkError{
+			Err:   fmt.Errorf("influxdb responded %s: %s", resp.Status, strings.TrimSpace(string(readBody))),
+			Retry: retriable(resp.StatusCode),
+			Wait:  retryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+
+	return nil
+}
+
+// writeURL returns the URL of the write endpoint of the configured API version
+func (InfluxSink *InfluxSink) writeURL() string {
+	query := url.Values{}
+	query.Set("precision", "ns")
+
+	endpoint := "/write"
+	if InfluxSink.Version == 2 {
+		endpoint = "/api/v2/write"
+		query.Set("org", InfluxSink.Organization)
+		query.Set("bucket", InfluxSink.Bucket)
+	} else {
+		query.Set("db", InfluxSink.Database)
+		if InfluxSink.RetentionPolicy != "" {
+			query.Set("rp", InfluxSink.RetentionPolicy)
+		}
+	}
+
+	return strings.TrimSuffix(InfluxSink.URL, "/") + endpoint + "?" + query.Encode()
+}
+
+// influxTagPrefix prefixes the metric tags named like an identification tag
+const influxTagPrefix = "tag_"
+
+// lineProtocol returns the metrics of every Snapshot of the Cache in line
+// protocol, one line per measurement and tag set, tagged with the
+// identification and hostname of the emitter and timestamped at the Snapshot.
+// Line protocol has no NaN nor infinity, such values are left out.
+func lineProtocol(cache *Cache) []string {
+	identification := map[string]string{
+		"organization": cache.Organization,
+		"group":        cache.Group,
+		"entity":       cache.Entity,
+	}
+	if cache.Server != nil {
+		identification["hostname"] = cache.Server.Hostname
+	}
+
+	var lines []string
+	for _, snapshot := range cache.Node {
+		timestamp := strconv.FormatInt(snapshot.Time.UnixNano(), 10)
+
+		// Fields sharing a measurement and tag set are written on the same line
+		var series []string
+		fields := make(map[string][]string)
+		for _, metric := range snapshot.FreshMetrics() {
+			if math.IsNaN(metric.Value) || math.IsInf(metric.Value, 0) {
+				continue
+			}
+
+			key := influxMeasurementEscaper.Replace(metric.Measurement) + influxTags(identification, metric.Tags)
+			if _, exists := fields[key]; !exists {
+				series = append(series, key)
+			}
+			fields[key] = append(fields[key], influxTagEscaper.Replace(metric.Field)+"="+strconv.FormatFloat(metric.Value, 'f', -1, 64))
+		}
+
+		for _, key := range series {
+			lines = append(lines, key+" "+strings.Join(fields[key], ",")+" "+timestamp)
+		}
+	}
+
+	return lines
+}
+
+// influxTags returns the tag set of a line, sorted by key as InfluxDB prefers.
+// Empty tags are left out since InfluxDB rejects them. The metric tags named
+// like an identification tag are prefixed, so they cannot replace it.
+func influxTags(identification map[string]string, tags map[string]string) string {
+	merged := make(map[string]string, len(identification)+len(tags))
+	for key, value := range tags {
+		if _, reserved := identification[key]; reserved {
+			key = influxTagPrefix + key
+		}
+		merged[key] = value
+	}
+	for key, value := range identification {
+		merged[key] = value
+	}
+
+	keys := make([]string, 0, len(merged))
+	for key, value := range merged {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var tagSet strings.Builder
+	for _, key := range keys {
+		tagSet.WriteString("," + influxTagEscaper.Replace(key) + "=" + influxTagEscaper.Replace(merged[key]))
+	}
+	return tagSet.String()
+}
@@ -0,0 +1,145 @@
+package runner
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/collector"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInfluxSink_Send(t *testing.T) {
+	type request struct {
+		uri           string
+		authorization string
+		body          string
+	}
+	var requests []request
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			reader, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = reader
+		}
+		readBody, _ := ioutil.ReadAll(body)
+		requests = append(requests, request{r.URL.RequestURI(), r.Header.Get("Authorization"), string(readBody)})
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	cache := Cache{
+		Organization: "org",
+		Group:        "group",
+		Entity:       "entity",
+		Server:       &Server{Hostname: "host 1"},
+		Node: []*Snapshot{{
+			Custom: map[string]collector.Collector{"test-ok": &testCollector{name: "test-ok"}},
+			Time:   time.Unix(1500000000, 5),
+		}},
+	}
+	valueLine := `test,entity=entity,group=group,hostname=host\ 1,name=test-ok,organization=org,quote=a\ "b" value=1.5 1500000000000000005` + "\n"
+	runsLine := `test,entity=entity,group=group,hostname=host\ 1,organization=org runs=3 1500000000000000005` + "\n"
+
+	tests := []struct {
+		options  map[string]interface{}
+		expected []request
+	}{
+		{
+			map[string]interface{}{"database": "sse", "username": "user", "password": "pass", "batch_size": float64(1)},
+			[]request{
+				{"/write?db=sse&precision=ns", "Basic dXNlcjpwYXNz", valueLine},
+				{"/write?db=sse&precision=ns", "Basic dXNlcjpwYXNz", runsLine},
+			},
+		},
+		{
+			map[string]interface{}{"version": float64(2), "organization": "org", "bucket": "sse", "token": "secret", "gzip": false},
+			[]request{
+				{"/api/v2/write?bucket=sse&org=org&precision=ns", "Token secret", valueLine + runsLine},
+			},
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			requests = nil
+			test.options["url"] = server.URL
+
+			sink, err := NewSink("influxdb", "influxdb")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = sink.Configure(test.options); err != nil {
+				t.Fatal(err)
+			}
+			if err = sink.Send(context.Background(), &cache); err != nil {
+				t.Fatal(err)
+			}
+
+			if fmt.Sprint(requests) != fmt.Sprint(test.expected) {
+				t.Fatalf("expected '%v', got '%v'", test.expected, requests)
+			}
+		})
+	}
+}
+
+func TestInfluxSink_Configure(t *testing.T) {
+	tests := []struct {
+		options  map[string]interface{}
+		expected string
+	}{
+		{map[string]interface{}{"url": "http://localhost:8086", "database": "sse"}, "<nil>"},
+		{map[string]interface{}{"database": "sse"}, `option "url" must be the URL of the InfluxDB server, got ""`},
+		{map[string]interface{}{"url": "http://localhost:8086"}, `option "database" is required by InfluxDB v1`},
+		{map[string]interface{}{"url": "http://localhost:8086", "version": float64(2), "bucket": "sse"}, `options "organization" and "bucket" are required by InfluxDB v2`},
+		{map[string]interface{}{"url": "http://localhost:8086", "version": float64(3)}, `option "version" must be 1 or 2, got 3`},
+		{map[string]interface{}{"url": "http://localhost:8086", "database": "sse", "batch_size": 1.5}, `option "batch_size" must be an integer`},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			sink := &InfluxSink{name: "influxdb"}
+			if actual := fmt.Sprint(sink.Configure(test.options)); actual != test.expected {
+				t.Fatalf("expected '%s', got '%s'", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestLineProtocol(t *testing.T) {
+	tests := []struct {
+		metrics  []collector.Metric
+		expected string
+	}{
+		{[]collector.Metric{{Measurement: "mem", Field: "free", Value: 1}}, "[mem,entity=entity,organization=org free=1 1500000000000000000]"},
+		{[]collector.Metric{{Measurement: "mem", Field: "free", Value: math.NaN()}, {Measurement: "mem", Field: "used", Value: 2}}, "[mem,entity=entity,organization=org used=2 1500000000000000000]"},
+		{[]collector.Metric{{Measurement: "mem", Field: "free", Value: math.Inf(1)}}, "[]"},
+		{[]collector.Metric{{Measurement: "app", Field: "up", Tags: map[string]string{"entity": "worker", "organization": ""}, Value: 1}}, "[app,entity=entity,organization=org,tag_entity=worker up=1 1500000000000000000]"},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			cache := &Cache{
+				Organization: "org",
+				Entity:       "entity",
+				Node: []*Snapshot{{
+					Custom: map[string]collector.Collector{"test-metrics": &metricsCollector{test.metrics}},
+					Time:   time.Unix(1500000000, 0),
+				}},
+			}
+			if actual := fmt.Sprint(lineProtocol(cache)); actual != test.expected {
+				t.Fatalf("expected '%s', got '%s'", test.expected, actual)
+			}
+		})
+	}
+}
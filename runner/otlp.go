@@ -0,0 +1,610 @@
+package runner
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/collector"
+	"github.com/jsanc623/ServerStatusEmitter/config"
+	error2 "github.com/jsanc623/ServerStatusEmitter/sphlog"
+	"github.com/shirou/gopsutil/host"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	otlpProtobuf = "protobuf"
+	otlpJSON     = "json"
+
+	// otlpCumulative is the CUMULATIVE AggregationTemporality, every counter we read is one
+	otlpCumulative = 2
+
+	otlpScope = "github.com/jsanc623/ServerStatusEmitter"
+)
+
+var (
+	// otlpStarted stands for the start of the counters when the boot time is not known
+	otlpStarted = time.Now()
+
+	// otlpBoot is the start of every cumulative point, the boot time of the
+	// host, read once so that a series always has the same start
+	otlpBoot     time.Time
+	otlpBootOnce sync.Once
+)
+
+// otelMetric describes the semantic convention metric a collector metric maps to
+type otelMetric struct {
+	name       string
+	unit       string
+	sum        bool    // false for gauges
+	monotonic  bool    // for sums: false for up-down counters
+	scale      float64 // converts the value to the unit, 1 when zero
+	attributes map[string]string
+}
+
+// otelMetrics maps the "measurement.field" of the collector metrics to the
+// OpenTelemetry semantic conventions for host metrics. The metrics missing
+// from it are exported as sse.<measurement>.<field>.
+var otelMetrics = map[string]otelMetric{
+	"cpu.user":          {"system.cpu.time", "s", true, true, 0, map[string]string{"cpu.mode": "user"}},
+	"cpu.system":        {"system.cpu.time", "s", true, true, 0, map[string]string{"cpu.mode": "system"}},
+	"cpu.idle":          {"system.cpu.time", "s", true, true, 0, map[string]string{"cpu.mode": "idle"}},
+	"cpu.nice":          {"system.cpu.time", "s", true, true, 0, map[string]string{"cpu.mode": "nice"}},
+	"cpu.iowait":        {"system.cpu.time", "s", true, true, 0, map[string]string{"cpu.mode": "iowait"}},
+	"cpu.irq":           {"system.cpu.time", "s", true, true, 0, map[string]string{"cpu.mode": "interrupt"}},
+	"cpu.softirq":       {"system.cpu.time", "s", true, true, 0, map[string]string{"cpu.mode": "softirq"}},
+	"cpu.steal":         {"system.cpu.time", "s", true, true, 0, map[string]string{"cpu.mode": "steal"}},
+	"cpu.guest":         {"system.cpu.time", "s", true, true, 0, map[string]string{"cpu.mode": "guest"}},
+	"cpu.guest_nice":    {"system.cpu.time", "s", true, true, 0, map[string]string{"cpu.mode": "guest_nice"}},
+	"cpu.count":         {"system.cpu.physical.count", "{cpu}", true, false, 0, nil},
+	"cpu.count_logical": {"system.cpu.logical.count", "{cpu}", true, false, 0, nil},
+
+	"mem.total":             {"system.memory.limit", "By", true, false, 0, nil},
+	"mem.available":         {"system.linux.memory.available", "By", true, false, 0, nil},
+	"mem.used":              {"system.memory.usage", "By", true, false, 0, map[string]string{"system.memory.state": "used"}},
+	"mem.free":              {"system.memory.usage", "By", true, false, 0, map[string]string{"system.memory.state": "free"}},
+	"mem.buffers":           {"system.memory.usage", "By", true, false, 0, map[string]string{"system.memory.state": "buffers"}},
+	"mem.cached":            {"system.memory.usage", "By", true, false, 0, map[string]string{"system.memory.state": "cached"}},
+	"mem.used_percent":      {"system.memory.utilization", "1", false, false, 0.01, map[string]string{"system.memory.state": "used"}},
+	"mem.swap_used":         {"system.paging.usage", "By", true, false, 0, map[string]string{"system.paging.state": "used"}},
+	"mem.swap_free":         {"system.paging.usage", "By", true, false, 0, map[string]string{"system.paging.state": "free"}},
+	"mem.swap_used_percent": {"system.paging.utilization", "1", false, false, 0.01, map[string]string{"system.paging.state": "used"}},
+
+	"disk.total":            {"system.filesystem.limit", "By", true, false, 0, nil},
+	"disk.used":             {"system.filesystem.usage", "By", true, false, 0, map[string]string{"system.filesystem.state": "used"}},
+	"disk.free":             {"system.filesystem.usage", "By", true, false, 0, map[string]string{"system.filesystem.state": "free"}},
+	"disk.used_percent":     {"system.filesystem.utilization", "1", false, false, 0.01, map[string]string{"system.filesystem.state": "used"}},
+	"disk.read_bytes":       {"system.disk.io", "By", true, true, 0, map[string]string{"disk.io.direction": "read"}},
+	"disk.write_bytes":      {"system.disk.io", "By", true, true, 0, map[string]string{"disk.io.direction": "write"}},
+	"disk.read_count":       {"system.disk.operations", "{operation}", true, true, 0, map[string]string{"disk.io.direction": "read"}},
+	"disk.write_count":      {"system.disk.operations", "{operation}", true, true, 0, map[string]string{"disk.io.direction": "write"}},
+	"disk.read_time":        {"system.disk.operation_time", "s", true, true, 0.001, map[string]string{"disk.io.direction": "read"}},
+	"disk.write_time":       {"system.disk.operation_time", "s", true, true, 0.001, map[string]string{"disk.io.direction": "write"}},
+	"disk.io_time":          {"system.disk.io_time", "s", true, true, 0.001, nil},
+	"disk.iops_in_progress": {"system.disk.pending_operations", "{operation}", true, false, 0, nil},
+
+	"net.bytes_sent":   {"system.network.io", "By", true, true, 0, map[string]string{"network.io.direction": "transmit"}},
+	"net.bytes_recv":   {"system.network.io", "By", true, true, 0, map[string]string{"network.io.direction": "receive"}},
+	"net.packets_sent": {"system.network.packets", "{packet}", true, true, 0, map[string]string{"network.io.direction": "transmit"}},
+	"net.packets_recv": {"system.network.packets", "{packet}", true, true, 0, map[string]string{"network.io.direction": "receive"}},
+	"net.err_out":      {"system.network.errors", "{error}", true, true, 0, map[string]string{"network.io.direction": "transmit"}},
+	"net.err_in":       {"system.network.errors", "{error}", true, true, 0, map[string]string{"network.io.direction": "receive"}},
+	"net.drop_out":     {"system.network.dropped", "{packet}", true, true, 0, map[string]string{"network.io.direction": "transmit"}},
+	"net.drop_in":      {"system.network.dropped", "{packet}", true, true, 0, map[string]string{"network.io.direction": "receive"}},
+
+	"system.load1":  {"system.cpu.load_average.1m", "{thread}", false, false, 0, nil},
+	"system.load5":  {"system.cpu.load_average.5m", "{thread}", false, false, 0, nil},
+	"system.load15": {"system.cpu.load_average.15m", "{thread}", false, false, 0, nil},
+	"system.uptime": {"system.uptime", "s", false, false, 0, nil},
+	"system.procs":  {"system.process.count", "{process}", true, false, 0, nil},
+}
+
+// otelAttributes maps the metric tags to semantic convention attributes
+var otelAttributes = map[string]string{
+	"cpu":       "cpu.logical_number",
+	"device":    "system.device",
+	"path":      "system.filesystem.mountpoint",
+	"interface": "network.interface.name",
+}
+
+// otelArchitectures maps the architectures lscpu and Go report to the host.arch values
+var otelArchitectures = map[string]string{
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"i386":    "x86",
+	"i686":    "x86",
+	"386":     "x86",
+	"armv7l":  "arm32",
+	"arm":     "arm32",
+	"ppc64":   "ppc64",
+	"ppc64le": "ppc64",
+	"s390x":   "s390x",
+}
+
+func init() {
+	RegisterSink("otlp", func(name string) Sink { return &OTLPSink{name: name} })
+}
+
+// OTLPSink exports the metrics of every Snapshot to an OpenTelemetry
+// collector over OTLP/HTTP, encoded in protobuf or JSON.
+type OTLPSink struct {
+	name string
+
+	// URL is the metrics endpoint, such as http://localhost:4318/v1/metrics
+	URL string
+
+	// Encoding is protobuf or json
+	Encoding string
+
+	// Headers are added to every request, such as an API key
+	Headers map[string]string
+
+	// Gzip compresses the requests
+	Gzip bool
+
+	// TLS applies the tls section of the configuration, kept to the mothership otherwise
+	TLS bool
+}
+
+// Name returns the name the sink is configured under
+func (OTLPSink *OTLPSink) Name() string {
+	return OTLPSink.name
+}
+
+// Configure reads the options of the sink: url, encoding (protobuf by
+// default), headers (an object of strings), gzip (true by default) and tls.
+func (OTLPSink *OTLPSink) Configure(options map[string]interface{}) error {
+	var err error
+	if OTLPSink.URL, err = collector.StringOption(options, "url", ""); err != nil {
+		return err
+	}
+	if OTLPSink.Encoding, err = collector.StringOption(options, "encoding", otlpProtobuf); err != nil {
+		return err
+	}
+	if OTLPSink.Gzip, err = collector.BoolOption(options, "gzip", true); err != nil {
+		return err
+	}
+	if OTLPSink.TLS, err = collector.BoolOption(options, "tls", false); err != nil {
+		return err
+	}
+
+	if u, err := url.Parse(OTLPSink.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("option \"url\" must be the URL of the OTLP metrics endpoint, got %q", OTLPSink.URL)
+	}
+	if OTLPSink.Encoding != otlpProtobuf && OTLPSink.Encoding != otlpJSON {
+		return fmt.Errorf("option \"encoding\" must be %s or %s, got %q", otlpProtobuf, otlpJSON, OTLPSink.Encoding)
+	}
+
+	OTLPSink.Headers = make(map[string]string)
+	if value, exists := options["headers"]; exists {
+		headers, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("option %q must be an object", "headers")
+		}
+		for key, value := range headers {
+			if OTLPSink.Headers[key], ok = value.(string); !ok {
+				return fmt.Errorf("header %q must be a string", key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Send exports the metrics of the Cache in a single request
+func (OTLPSink *OTLPSink) Send(ctx context.Context, cache *Cache) error {
+	request := otlpMetrics(cache)
+	if len(request.ResourceMetrics[0].ScopeMetrics[0].Metrics) == 0 {
+		return nil
+	}
+
+	body := request.proto()
+	contentType := "application/x-protobuf"
+	if OTLPSink.Encoding == otlpJSON {
+		var err error
+		if body, err = json.Marshal(request); err != nil {
+			return &SinkError{Err: err}
+		}
+		contentType = "application/json"
+	}
+
+	if OTLPSink.Gzip {
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		if _, err := writer.Write(body); err != nil {
+			return &SinkError{Err: err}
+		}
+		if err := writer.Close(); err != nil {
+			return &SinkError{Err: err}
+		}
+		body = buffer.Bytes()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", OTLPSink.URL, bytes.NewReader(body))
+	if err != nil {
+		return &SinkError{Err: err}
+	}
+
+	for key, value := range OTLPSink.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if OTLPSink.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := client(OTLPSink.TLS).Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	_, _ = ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &SinkError{
+			Err:   fmt.Errorf("otlp endpoint responded %s", resp.Status),
+			Retry: retriable(resp.StatusCode),
+			Wait:  retryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+
+	return nil
+}
+
+// otlpMetrics returns the export request of the metrics of every Snapshot
+// of the Cache, described by the resource attributes of the Server.
+func otlpMetrics(cache *Cache) *otlpRequest {
+	metrics := make(map[string]*otlpMetric)
+	var names []string
+
+	for _, snapshot := range cache.Node {
+		timestamp := uint64(snapshot.Time.UnixNano())
+
+		// Counters run since boot
+		start := uint64(otlpBootTime().UnixNano())
+
+		for _, metric := range snapshot.FreshMetrics() {
+			mapping, exists := otelMetrics[metric.Measurement+"."+metric.Field]
+			if !exists {
+				mapping = otelMetric{name: "sse." + metric.Measurement + "." + metric.Field, sum: metric.Counter, monotonic: metric.Counter}
+			}
+
+			otlp, exists := metrics[mapping.name]
+			if !exists {
+				otlp = &otlpMetric{Name: mapping.name, Unit: mapping.unit}
+				if mapping.sum {
+					otlp.Sum = &otlpSum{AggregationTemporality: otlpCumulative, IsMonotonic: mapping.monotonic}
+				} else {
+					otlp.Gauge = &otlpGauge{}
+				}
+				metrics[mapping.name] = otlp
+				names = append(names, mapping.name)
+			}
+
+			value := metric.Value
+			if mapping.scale != 0 {
+				value *= mapping.scale
+			}
+			point := otlpDataPoint{Attributes: otlpPointAttributes(mapping.attributes, metric.Tags), TimeUnixNano: timestamp, AsDouble: value}
+
+			if otlp.Sum != nil {
+				point.StartTimeUnixNano = start
+				otlp.Sum.DataPoints = append(otlp.Sum.DataPoints, point)
+			} else {
+				otlp.Gauge.DataPoints = append(otlp.Gauge.DataPoints, point)
+			}
+		}
+	}
+
+	scope := otlpScopeMetrics{Scope: otlpInstrumentationScope{Name: otlpScope, Version: config.Version}}
+	for _, name := range names {
+		scope.Metrics = append(scope.Metrics, *metrics[name])
+	}
+
+	return &otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource:     otlpResource{Attributes: otlpResourceAttributes(cache)},
+		ScopeMetrics: []otlpScopeMetrics{scope},
+	}}}
+}
+
+// otlpBootTime returns the boot time of the host, or the start of the
+// emitter when it cannot be read
+func otlpBootTime() time.Time {
+	otlpBootOnce.Do(func() {
+		otlpBoot = otlpStarted
+		boot, err := host.BootTime()
+		if err != nil {
+			error2.LogError(fmt.Errorf("otlp: counters start with the emitter, the boot time is unknown: %s", err))
+			return
+		}
+		otlpBoot = time.Unix(int64(boot), 0)
+	})
+	return otlpBoot
+}
+
+// otlpResourceAttributes describes the host and the emitter
+func otlpResourceAttributes(cache *Cache) []otlpKeyValue {
+	architecture := runtime.GOARCH
+	var attributes []otlpKeyValue
+	add := func(key string, value string) {
+		if value != "" {
+			attributes = append(attributes, otlpString(key, value))
+		}
+	}
+
+	add("service.name", "ServerStatusEmitter")
+	add("service.version", cache.Version)
+	add("service.instance.id", cache.ID)
+	if cache.Server != nil {
+		add("host.name", cache.Server.Hostname)
+		add("os.description", cache.Server.OperatingSystem.Distributor)
+		if cache.Server.Hardware.Architecture != "" {
+			architecture = cache.Server.Hardware.Architecture
+		}
+	}
+	add("os.type", runtime.GOOS)
+	if arch, exists := otelArchitectures[architecture]; exists {
+		add("host.arch", arch)
+	} else {
+		add("host.arch", architecture)
+	}
+	add("sse.organization", cache.Organization)
+	add("sse.group", cache.Group)
+	add("sse.entity", cache.Entity)
+
+	return attributes
+}
+
+// otlpPointAttributes returns the attributes of a data point, sorted by key
+func otlpPointAttributes(attributes map[string]string, tags map[string]string) []otlpKeyValue {
+	merged := make(map[string]string, len(attributes)+len(tags))
+	for key, value := range tags {
+		if attribute, exists := otelAttributes[key]; exists {
+			key = attribute
+		}
+		merged[key] = value
+	}
+	for key, value := range attributes {
+		merged[key] = value
+	}
+
+	keys := make([]string, 0, len(merged))
+	for key := range merged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var keyValues []otlpKeyValue
+	for _, key := range keys {
+		value := merged[key]
+		if key == "cpu.logical_number" {
+			// cpu0, cpu1... are numbers, cpu-total is not a single cpu
+			if number, err := strconv.ParseInt(strings.TrimPrefix(value, "cpu"), 10, 64); err == nil {
+				keyValues = append(keyValues, otlpKeyValue{Key: key, Value: otlpAnyValue{IntValue: &number}})
+			}
+			continue
+		}
+		keyValues = append(keyValues, otlpString(key, value))
+	}
+	return keyValues
+}
+
+// otlpString returns a string attribute
+func otlpString(key string, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: &value}}
+}
+
+// The OTLP metrics messages, encoded to JSON through their field tags and
+// to protobuf through their proto methods, after the field numbers of
+// opentelemetry/proto/collector/metrics/v1/metrics_service.proto
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpInstrumentationScope `json:"scope"`
+	Metrics []otlpMetric             `json:"metrics"`
+}
+
+type otlpInstrumentationScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpMetric struct {
+	Name  string     `json:"name"`
+	Unit  string     `json:"unit,omitempty"`
+	Gauge *otlpGauge `json:"gauge,omitempty"`
+	Sum   *otlpSum   `json:"sum,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano uint64         `json:"startTimeUnixNano,string,omitempty"`
+	TimeUnixNano      uint64         `json:"timeUnixNano,string"`
+	AsDouble          float64        `json:"asDouble"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *int64  `json:"intValue,string,omitempty"`
+}
+
+func (request *otlpRequest) proto() []byte {
+	var buffer protoBuffer
+	for _, resourceMetrics := range request.ResourceMetrics {
+		buffer.message(1, resourceMetrics.proto())
+	}
+	return buffer
+}
+
+func (resourceMetrics *otlpResourceMetrics) proto() []byte {
+	var buffer protoBuffer
+	buffer.message(1, resourceMetrics.Resource.proto())
+	for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+		buffer.message(2, scopeMetrics.proto())
+	}
+	return buffer
+}
+
+func (resource *otlpResource) proto() []byte {
+	var buffer protoBuffer
+	for _, attribute := range resource.Attributes {
+		buffer.message(1, attribute.proto())
+	}
+	return buffer
+}
+
+func (scopeMetrics *otlpScopeMetrics) proto() []byte {
+	var buffer protoBuffer
+	buffer.message(1, scopeMetrics.Scope.proto())
+	for _, metric := range scopeMetrics.Metrics {
+		buffer.message(2, metric.proto())
+	}
+	return buffer
+}
+
+func (scope *otlpInstrumentationScope) proto() []byte {
+	var buffer protoBuffer
+	buffer.string(1, scope.Name)
+	buffer.string(2, scope.Version)
+	return buffer
+}
+
+func (metric *otlpMetric) proto() []byte {
+	var buffer protoBuffer
+	buffer.string(1, metric.Name)
+	buffer.string(3, metric.Unit)
+	if metric.Gauge != nil {
+		var gauge protoBuffer
+		for _, point := range metric.Gauge.DataPoints {
+			gauge.message(1, point.proto())
+		}
+		buffer.message(5, gauge)
+	}
+	if metric.Sum != nil {
+		var sum protoBuffer
+		for _, point := range metric.Sum.DataPoints {
+			sum.message(1, point.proto())
+		}
+		sum.varint(2, uint64(metric.Sum.AggregationTemporality))
+		if metric.Sum.IsMonotonic {
+			sum.varint(3, 1)
+		}
+		buffer.message(7, sum)
+	}
+	return buffer
+}
+
+func (point *otlpDataPoint) proto() []byte {
+	var buffer protoBuffer
+	if point.StartTimeUnixNano != 0 {
+		buffer.fixed64(2, point.StartTimeUnixNano)
+	}
+	buffer.fixed64(3, point.TimeUnixNano)
+	buffer.fixed64(4, math.Float64bits(point.AsDouble))
+	for _, attribute := range point.Attributes {
+		buffer.message(7, attribute.proto())
+	}
+	return buffer
+}
+
+func (keyValue *otlpKeyValue) proto() []byte {
+	var value protoBuffer
+	if keyValue.Value.StringValue != nil {
+		value.message(1, []byte(*keyValue.Value.StringValue))
+	}
+	if keyValue.Value.IntValue != nil {
+		value.varint(3, uint64(*keyValue.Value.IntValue))
+	}
+
+	var buffer protoBuffer
+	buffer.string(1, keyValue.Key)
+	buffer.message(2, value)
+	return buffer
+}
+
+// protoBuffer appends protobuf wire format fields
+type protoBuffer []byte
+
+// Wire types of the protobuf fields
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+)
+
+func (buffer *protoBuffer) uvarint(value uint64) {
+	var encoded [binary.MaxVarintLen64]byte
+	*buffer = append(*buffer, encoded[:binary.PutUvarint(encoded[:], value)]...)
+}
+
+func (buffer *protoBuffer) tag(field int, wireType int) {
+	buffer.uvarint(uint64(field<<3 | wireType))
+}
+
+func (buffer *protoBuffer) varint(field int, value uint64) {
+	buffer.tag(field, protoVarint)
+	buffer.uvarint(value)
+}
+
+func (buffer *protoBuffer) fixed64(field int, value uint64) {
+	var encoded [8]byte
+	binary.LittleEndian.PutUint64(encoded[:], value)
+	buffer.tag(field, protoFixed64)
+	*buffer = append(*buffer, encoded[:]...)
+}
+
+func (buffer *protoBuffer) message(field int, value []byte) {
+	buffer.tag(field, protoBytes)
+	buffer.uvarint(uint64(len(value)))
+	*buffer = append(*buffer, value...)
+}
+
+// string leaves out empty strings, as proto3 does
+func (buffer *protoBuffer) string(field int, value string) {
+	if value != "" {
+		buffer.message(field, []byte(value))
+	}
+}
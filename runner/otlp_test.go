@@ -0,0 +1,247 @@
+package runner
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/collector"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// metricsCollector is a custom collector reporting the metrics it is given
+type metricsCollector struct {
+	metrics []collector.Metric
+}
+
+func (c *metricsCollector) Name() string {
+	return "test-metrics"
+}
+
+func (c *metricsCollector) Configure(options map[string]interface{}) error {
+	return nil
+}
+
+func (c *metricsCollector) Collect(ctx context.Context) error {
+	return nil
+}
+
+func (c *metricsCollector) Metrics() []collector.Metric {
+	return c.metrics
+}
+
+// protoField is a field of a protobuf message, its value is the varint, the fixed64 or the bytes
+type protoField struct {
+	number int
+	value  uint64
+	bytes  []byte
+}
+
+// decodeProto splits a protobuf message into its fields
+func decodeProto(t *testing.T, message []byte) []protoField {
+	var fields []protoField
+	for len(message) > 0 {
+		tag, n := binary.Uvarint(message)
+		message = message[n:]
+
+		field := protoField{number: int(tag >> 3)}
+		switch tag & 7 {
+		case protoVarint:
+			field.value, n = binary.Uvarint(message)
+			message = message[n:]
+		case protoFixed64:
+			field.value = binary.LittleEndian.Uint64(message)
+			message = message[8:]
+		case protoBytes:
+			length, n := binary.Uvarint(message)
+			field.bytes = message[n : n+int(length)]
+			message = message[n+int(length):]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// protoPath returns the fields found by following the field numbers of path
+func protoPath(t *testing.T, message []byte, path ...int) []protoField {
+	fields := []protoField{{bytes: message}}
+	for _, number := range path {
+		var next []protoField
+		for _, field := range fields {
+			for _, nested := range decodeProto(t, field.bytes) {
+				if nested.number == number {
+					next = append(next, nested)
+				}
+			}
+		}
+		fields = next
+	}
+	return fields
+}
+
+func TestOTLPSink_Send(t *testing.T) {
+	var received []byte
+	var contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		received, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	server1 := &Server{Hostname: "web-01"}
+	server1.Hardware.Architecture = "x86_64"
+	cache := Cache{
+		Organization: "org",
+		Server:       server1,
+		Node: []*Snapshot{{
+			Custom: map[string]collector.Collector{"test-metrics": &metricsCollector{[]collector.Metric{
+				{Measurement: "cpu", Field: "user", Tags: map[string]string{"cpu": "cpu1"}, Value: 12.5, Counter: true},
+				{Measurement: "mem", Field: "used_percent", Value: 50},
+				{Measurement: "system", Field: "uptime", Value: 100},
+				{Measurement: "test", Field: "value", Value: 1.5},
+			}}},
+			Time: time.Unix(1500000000, 0),
+		}, {
+			// a later snapshot, without the uptime
+			Custom: map[string]collector.Collector{"test-metrics": &metricsCollector{[]collector.Metric{
+				{Measurement: "cpu", Field: "user", Tags: map[string]string{"cpu": "cpu1"}, Value: 13.5, Counter: true},
+			}}},
+			Time: time.Unix(1500000010, 0),
+		}},
+	}
+
+	for _, encoding := range []string{otlpProtobuf, otlpJSON} {
+		t.Run(encoding, func(t *testing.T) {
+			sink, err := NewSink("otlp", "otlp")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = sink.Configure(map[string]interface{}{"url": server.URL + "/v1/metrics", "encoding": encoding, "gzip": false}); err != nil {
+				t.Fatal(err)
+			}
+			if err = sink.Send(context.Background(), &cache); err != nil {
+				t.Fatal(err)
+			}
+
+			if encoding == otlpJSON {
+				if contentType != "application/json" {
+					t.Fatalf("expected 'application/json', got '%s'", contentType)
+				}
+				var request otlpRequest
+				if err = json.Unmarshal(received, &request); err != nil {
+					t.Fatal(err)
+				}
+				var names []string
+				for _, metric := range request.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+					names = append(names, metric.Name)
+				}
+				expected := "[system.cpu.time system.memory.utilization system.uptime sse.test.value]"
+				if fmt.Sprint(names) != expected {
+					t.Fatalf("expected '%s', got '%v'", expected, names)
+				}
+				return
+			}
+
+			if contentType != "application/x-protobuf" {
+				t.Fatalf("expected 'application/x-protobuf', got '%s'", contentType)
+			}
+
+			var names []string
+			for _, name := range protoPath(t, received, 1, 2, 2, 1) {
+				names = append(names, string(name.bytes))
+			}
+			expected := "[system.cpu.time system.memory.utilization system.uptime sse.test.value]"
+			if fmt.Sprint(names) != expected {
+				t.Fatalf("expected '%s', got '%v'", expected, names)
+			}
+
+			var attributes []string
+			for _, attribute := range protoPath(t, received, 1, 1, 1) {
+				fields := decodeProto(t, attribute.bytes)
+				attributes = append(attributes, string(fields[0].bytes)+"="+string(decodeProto(t, fields[1].bytes)[0].bytes))
+			}
+			for _, attribute := range []string{"host.name=web-01", "host.arch=amd64", "sse.organization=org"} {
+				if !contains(attributes, attribute) {
+					t.Fatalf("expected '%s', got '%v'", attribute, attributes)
+				}
+			}
+
+			// system.cpu.time is a monotonic cumulative sum started at boot, whatever
+			// the snapshot, with its cpu and mode attributes
+			sum := protoPath(t, received, 1, 2, 2, 7)[0].bytes
+			point := protoPath(t, sum, 1)[0].bytes
+			checks := []struct {
+				name     string
+				actual   uint64
+				expected uint64
+			}{
+				{"temporality", protoPath(t, sum, 2)[0].value, otlpCumulative},
+				{"monotonic", protoPath(t, sum, 3)[0].value, 1},
+				{"start", protoPath(t, point, 2)[0].value, uint64(otlpBootTime().UnixNano())},
+				{"same start", protoPath(t, protoPath(t, sum, 1)[1].bytes, 2)[0].value, uint64(otlpBootTime().UnixNano())},
+				{"time", protoPath(t, point, 3)[0].value, uint64(time.Unix(1500000000, 0).UnixNano())},
+				{"value", protoPath(t, point, 4)[0].value, math.Float64bits(12.5)},
+				{"cpu", protoPath(t, point, 7, 2, 3)[0].value, 1},
+			}
+			for _, check := range checks {
+				if check.actual != check.expected {
+					t.Fatalf("expected %s '%d', got '%d'", check.name, check.expected, check.actual)
+				}
+			}
+
+			// mem.used_percent is a gauge scaled to a ratio
+			gauge := protoPath(t, received, 1, 2, 2, 5, 1, 4)[0].value
+			if math.Float64frombits(gauge) != 0.5 {
+				t.Fatalf("expected '0.5', got '%g'", math.Float64frombits(gauge))
+			}
+		})
+	}
+}
+
+func TestOTLPRequest_Encode(t *testing.T) {
+	host, cpu := "h", int64(1)
+	request := otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{{Key: "host.name", Value: otlpAnyValue{StringValue: &host}}}},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope: otlpInstrumentationScope{Name: "sse"},
+			Metrics: []otlpMetric{{Name: "m", Unit: "1", Gauge: &otlpGauge{DataPoints: []otlpDataPoint{{
+				Attributes:   []otlpKeyValue{{Key: "cpu", Value: otlpAnyValue{IntValue: &cpu}}},
+				TimeUnixNano: 1,
+				AsDouble:     1.5,
+			}}}}},
+		}},
+	}}}
+
+	// ExportMetricsServiceRequest.resource_metrics = 1, ResourceMetrics.resource = 1 and
+	// scope_metrics = 2, Resource.attributes = 1, ScopeMetrics.scope = 1 and metrics = 2,
+	// Metric.name = 1, unit = 3 and gauge = 5, Gauge.data_points = 1, NumberDataPoint.time_unix_nano = 3,
+	// as_double = 4 and attributes = 7, KeyValue.key = 1 and value = 2, AnyValue.string_value = 1 and int_value = 3
+	expected := "0a46" +
+		"0a12" + "0a10" + "0a09" + hex.EncodeToString([]byte("host.name")) + "1203" + "0a0168" +
+		"1230" + "0a05" + "0a03" + hex.EncodeToString([]byte("sse")) +
+		"1227" + "0a016d" + "1a0131" + "2a1f" + "0a1d" +
+		"19" + "0100000000000000" + "21" + "000000000000f83f" +
+		"3a09" + "0a03" + hex.EncodeToString([]byte("cpu")) + "1202" + "1801"
+	if actual := hex.EncodeToString(request.proto()); actual != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, actual)
+	}
+
+	expected = `{"resourceMetrics":[{"resource":{"attributes":[{"key":"host.name","value":{"stringValue":"h"}}]},` +
+		`"scopeMetrics":[{"scope":{"name":"sse"},"metrics":[{"name":"m","unit":"1","gauge":{"dataPoints":[` +
+		`{"attributes":[{"key":"cpu","value":{"intValue":"1"}}],"timeUnixNano":"1","asDouble":1.5}]}}]}]}]}`
+	actual, err := json.Marshal(&request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, actual)
+	}
+}
@@ -0,0 +1,180 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/collector"
+	"github.com/jsanc623/ServerStatusEmitter/config"
+	error2 "github.com/jsanc623/ServerStatusEmitter/sphlog"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	prometheusNamespace   = "sse"
+	prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+	defaultPrometheusPath = "/metrics"
+)
+
+var (
+	// latest holds the most recent *Snapshot taken by a Scheduler
+	latest atomic.Value
+
+	prometheusNameRgx = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+	// prometheusEscaper escapes the label values
+	prometheusEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// Latest returns the most recent Snapshot taken by a Scheduler, or nil
+func Latest() *Snapshot {
+	snapshot, _ := latest.Load().(*Snapshot)
+	return snapshot
+}
+
+// ServePrometheus starts serving the latest Snapshot in the Prometheus
+// text exposition format, as configured in the prometheus settings.
+// The returned server is already listening.
+func ServePrometheus() (*http.Server, error) {
+	settings := config.Current().Settings.Prometheus
+
+	path := settings.Path
+	if path == "" {
+		path = defaultPrometheusPath
+	}
+
+	listener, err := net.Listen("tcp", settings.ListenAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, PrometheusHandler())
+	server := &http.Server{Handler: mux}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			error2.LogError(err)
+		}
+	}()
+
+	error2.LogInfo("serving Prometheus metrics on " + listener.Addr().String() + path)
+	return server, nil
+}
+
+// PrometheusHandler serves the latest Snapshot in the Prometheus text
+// exposition format, labelled with the identification of this emitter.
+func PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snapshot := Latest()
+		if snapshot == nil {
+			http.Error(w, "no snapshot collected yet", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", prometheusContentType)
+		writer := bufio.NewWriter(w)
+		writePrometheus(writer, snapshot, config.Current())
+		_ = writer.Flush()
+	})
+}
+
+// prometheusFamily is the samples of a metric name, with its type
+type prometheusFamily struct {
+	kind    string
+	help    string
+	samples []string
+}
+
+// writePrometheus writes the metrics of a Snapshot in the text exposition format
+func writePrometheus(w *bufio.Writer, snapshot *Snapshot, conf *config.Config) {
+	identification := map[string]string{
+		"id":           conf.Identification.ID,
+		"organization": conf.Identification.Organization,
+		"group":        conf.Identification.Group,
+		"entity":       conf.Identification.Entity,
+	}
+
+	families := make(map[string]*prometheusFamily)
+	add := func(name string, kind string, help string, tags map[string]string, value float64) {
+		family, exists := families[name]
+		if !exists {
+			family = &prometheusFamily{kind: kind, help: help}
+			families[name] = family
+		}
+		family.samples = append(family.samples, name+prometheusLabels(identification, tags)+" "+strconv.FormatFloat(value, 'g', -1, 64))
+	}
+
+	for _, metric := range snapshot.Metrics() {
+		name, kind := prometheusName(metric)
+		add(name, kind, fmt.Sprintf("%s %s reported by the emitter", metric.Measurement, metric.Field), metric.Tags, metric.Value)
+	}
+
+	// Let Prometheus tell the collectors which failed from the ones which are not enabled
+	collectors := collector.Names()
+	for _, name := range collectors {
+		if !conf.CollectorEnabled(name) {
+			continue
+		}
+		up := 1.0
+		for _, collectorError := range snapshot.Errors {
+			if collectorError.Collector == name {
+				up = 0
+			}
+		}
+		add(prometheusNamespace+"_collector_up", "gauge", "whether the last run of the collector succeeded", map[string]string{"collector": name}, up)
+	}
+	add(prometheusNamespace+"_snapshot_timestamp_seconds", "gauge", "when the snapshot was taken", nil, float64(snapshot.Time.UnixNano())/1e9)
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		family := families[name]
+		_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, family.help, name, family.kind)
+		for _, sample := range family.samples {
+			_, _ = w.WriteString(sample + "\n")
+		}
+	}
+}
+
+// prometheusName returns the Prometheus name and type of a metric
+func prometheusName(metric collector.Metric) (string, string) {
+	name := prometheusNameRgx.ReplaceAllString(prometheusNamespace+"_"+metric.Measurement+"_"+metric.Field, "_")
+	if metric.Counter {
+		return name + "_total", "counter"
+	}
+	return name, "gauge"
+}
+
+// prometheusLabels returns the label set of a sample, identification first
+func prometheusLabels(identification map[string]string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var labels []string
+	for _, key := range []string{"id", "organization", "group", "entity"} {
+		labels = append(labels, key+`="`+prometheusEscape(identification[key])+`"`)
+	}
+	for _, key := range keys {
+		labels = append(labels, prometheusNameRgx.ReplaceAllString(key, "_")+`="`+prometheusEscape(tags[key])+`"`)
+	}
+
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// prometheusEscape escapes a label value
+func prometheusEscape(value string) string {
+	return prometheusEscaper.Replace(value)
+}
@@ -0,0 +1,79 @@
+package runner
+
+import (
+	"github.com/jsanc623/ServerStatusEmitter/collector"
+	"github.com/jsanc623/ServerStatusEmitter/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Metrics makes the test collectors measurable
+func (c *testCollector) Metrics() []collector.Metric {
+	return []collector.Metric{
+		{Measurement: "test", Field: "value", Tags: map[string]string{"name": c.name, "quote": `a "b"`}, Value: 1.5},
+		{Measurement: "test", Field: "runs", Value: 3, Counter: true},
+	}
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	conf := testConfig(t)
+	conf.Identification.ID = "id-1"
+	conf.Identification.Key = "secret"
+	conf.Identification.Organization = "org"
+	conf.Identification.Group = "group"
+	conf.Identification.Entity = "entity"
+	config.Store(&conf)
+	defer config.Store(&config.Config{})
+
+	latest.Store(&Snapshot{
+		Custom: map[string]collector.Collector{"test-ok": &testCollector{name: "test-ok"}},
+		Errors: []CollectorError{{Collector: "test-fail", Error: "collection failed"}},
+		Time:   time.Unix(1500000000, 0),
+	})
+	defer latest.Store((*Snapshot)(nil))
+
+	recorder := httptest.NewRecorder()
+	PrometheusHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected '%d', got '%d'", http.StatusOK, recorder.Code)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != prometheusContentType {
+		t.Fatalf("expected '%s', got '%s'", prometheusContentType, contentType)
+	}
+
+	body := recorder.Body.String()
+	labels := `id="id-1",organization="org",group="group",entity="entity"`
+	tests := []string{
+		"# TYPE sse_test_value gauge",
+		`sse_test_value{` + labels + `,name="test-ok",quote="a \"b\""} 1.5`,
+		"# TYPE sse_test_runs_total counter",
+		`sse_test_runs_total{` + labels + `} 3`,
+		`sse_collector_up{` + labels + `,collector="test-fail"} 0`,
+		`sse_collector_up{` + labels + `,collector="test-ok"} 1`,
+		`sse_snapshot_timestamp_seconds{` + labels + `} 1.5e+09`,
+	}
+
+	for _, expected := range tests {
+		if !strings.Contains(body, expected+"\n") {
+			t.Fatalf("expected '%s', got '%s'", expected, body)
+		}
+	}
+	if strings.Contains(body, "secret") {
+		t.Fatalf("expected no key, got '%s'", body)
+	}
+}
+
+func TestPrometheusHandler_NoSnapshot(t *testing.T) {
+	latest.Store((*Snapshot)(nil))
+
+	recorder := httptest.NewRecorder()
+	PrometheusHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected '%d', got '%d'", http.StatusServiceUnavailable, recorder.Code)
+	}
+}
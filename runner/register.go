@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"github.com/jsanc623/ServerStatusEmitter/config"
 	"github.com/jsanc623/ServerStatusEmitter/helper"
 	error2 "github.com/jsanc623/ServerStatusEmitter/sphlog"
 	"io/ioutil"
@@ -27,8 +28,12 @@ func Register(registrationObject map[string]interface{}, registrationURL string)
 	req.Header.Set("X-Custom-Header", "REG")
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	conf := config.Current()
+	if err = Sign(req, jsonStr, conf.Identification.ID, conf.Identification.Key); err != nil {
+		return "", err
+	}
+
+	resp, err := client(true).Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,161 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/config"
+	"github.com/jsanc623/ServerStatusEmitter/helper"
+	error2 "github.com/jsanc623/ServerStatusEmitter/sphlog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultMaxAttempts = 3
+	defaultBaseDelay   = time.Second
+	defaultMaxDelay    = 30 * time.Second
+)
+
+// retryPolicy describes how many times, and how far apart,
+// a report is attempted before we give up on it.
+type retryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+// newRetryPolicy returns the retry policy from the reporting
+// configuration, falling back to defaults for unset values.
+func newRetryPolicy() retryPolicy {
+	retry := config.Current().Settings.Reporting.Retry
+
+	policy := retryPolicy{
+		MaxAttempts: retry.MaxAttempts,
+		BaseDelay:   time.Duration(retry.BaseDelayMilliseconds) * time.Millisecond,
+		MaxDelay:    time.Duration(retry.MaxDelayMilliseconds) * time.Millisecond,
+		Jitter:      retry.Jitter,
+	}
+
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = defaultMaxAttempts
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = defaultBaseDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = defaultMaxDelay
+	}
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		policy.Jitter = 0
+	}
+
+	return policy
+}
+
+// override returns the policy with the fields set in a sink retry configuration replaced
+func (policy retryPolicy) override(maxAttempts int, baseDelayMilliseconds int, maxDelayMilliseconds int, jitter float64) retryPolicy {
+	if maxAttempts > 0 {
+		policy.MaxAttempts = maxAttempts
+	}
+	if baseDelayMilliseconds > 0 {
+		policy.BaseDelay = time.Duration(baseDelayMilliseconds) * time.Millisecond
+	}
+	if maxDelayMilliseconds > 0 {
+		policy.MaxDelay = time.Duration(maxDelayMilliseconds) * time.Millisecond
+	}
+	if jitter > 0 && jitter <= 1 {
+		policy.Jitter = jitter
+	}
+	return policy
+}
+
+// do makes attempts at what, until one succeeds, a failed one is not
+// worth retrying, the policy gives up or ctx is done. Each attempt tells
+// us whether it is worth retrying and how long we were asked to wait,
+// which is capped to MaxDelay. The failed attempts which are retried are
+// logged, the last one is returned for the caller to log.
+func (policy retryPolicy) do(ctx context.Context, what string, attempt func() (bool, time.Duration, error)) error {
+	for attempts := 1; ; attempts++ {
+		retry, wait, err := attempt()
+		if err == nil {
+			return nil
+		}
+
+		if !retry || attempts >= policy.MaxAttempts {
+			return fmt.Errorf("%s failed after %d attempts: %s", what, attempts, err)
+		}
+
+		if wait > policy.MaxDelay {
+			wait = policy.MaxDelay
+		}
+
+		delay := policy.delay(attempts)
+		if wait > delay {
+			delay = wait
+		}
+
+		error2.LogWarn(fmt.Sprintf("retrying %s in %s (attempt %d of %d): %s", what, delay, attempts+1, policy.MaxAttempts, err))
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%s abandoned after %d attempts: %s: %s", what, attempts, ctx.Err(), err)
+		}
+	}
+}
+
+// delay returns how long to wait before the given retry (1 for the
+// first retry). The delay grows exponentially up to MaxDelay, and
+// up to Jitter of it is randomized so that many emitters recovering
+// together do not retry at the same instant.
+func (policy retryPolicy) delay(retry int) time.Duration {
+	delay := policy.BaseDelay
+	for i := 1; i < retry && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+
+	return delay - time.Duration(rand.Float64()*policy.Jitter*float64(delay))
+}
+
+// retriable tells us if a response status code is worth retrying
+func retriable(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// retryAfter parses a Retry-After header, given either in seconds or
+// as an HTTP date. It returns zero if the header is missing or invalid.
+func retryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+
+	return 0
+}
+
+// client returns an HTTP client bounded by the configured request timeout,
+// sharing the transport of the outbound requests, or the one of the requests
+// to the mothership when withTLS is set, which applies the tls section
+func client(withTLS bool) *http.Client {
+	if withTLS {
+		return helper.MothershipClient(config.Current().RequestTimeout())
+	}
+	return helper.Client(config.Current().RequestTimeout())
+}
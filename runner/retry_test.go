@@ -0,0 +1,135 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := retryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	tests := []struct {
+		retry    int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{10, 5 * time.Second},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			actual := policy.delay(test.retry)
+			if actual != test.expected {
+				t.Fatalf("expected '%s', got '%s'", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2019, 12, 17, 23, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-1", 0},
+		{"Tue, 17 Dec 2019 23:00:30 GMT", 30 * time.Second},
+		{"Tue, 17 Dec 2019 22:00:00 GMT", 0},
+		{"soon", 0},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			actual := retryAfter(test.value, now)
+			if actual != test.expected {
+				t.Fatalf("expected '%s', got '%s'", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	policy := retryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	failed := errors.New("unavailable")
+
+	// a longer Retry-After than MaxDelay is capped, rather than giving up
+	tests := []struct {
+		waits    []time.Duration
+		expected string
+	}{
+		{[]time.Duration{time.Hour}, "<nil>"},
+		{[]time.Duration{0, time.Hour}, "<nil>"},
+		{[]time.Duration{0, 0, 0}, "report failed after 3 attempts: unavailable"},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			attempts := 0
+			start := time.Now()
+			err := policy.do(context.Background(), "report", func() (bool, time.Duration, error) {
+				attempts++
+				if attempts > len(test.waits) {
+					return false, 0, nil
+				}
+				return true, test.waits[attempts-1], failed
+			})
+
+			if fmt.Sprint(err) != test.expected {
+				t.Fatalf("expected '%s', got '%v'", test.expected, err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Fatalf("expected the waits to be capped, took %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestOutput_Deliver(t *testing.T) {
+	var conf config.Config
+	conf.Settings.Reporting.Retry.MaxAttempts = 3
+	conf.Settings.Reporting.Retry.BaseDelayMilliseconds = 1
+	conf.Settings.Reporting.Retry.MaxDelayMilliseconds = 1
+	config.Store(&conf)
+	defer config.Store(&config.Config{})
+
+	tests := []struct {
+		statuses []int
+		expected bool
+		attempts int
+	}{
+		{[]int{http.StatusOK}, true, 1},
+		{[]int{http.StatusServiceUnavailable, http.StatusOK}, true, 2},
+		{[]int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusOK}, true, 3},
+		{[]int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}, false, 3},
+		{[]int{http.StatusBadRequest}, false, 1},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			var attempts int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.statuses[attempts])
+				attempts++
+			}))
+			defer server.Close()
+
+			output := &Output{Sink: &MothershipSink{URL: server.URL}, policy: newRetryPolicy()}
+			actual := output.deliver(context.Background(), &Cache{}) == nil
+			if actual != test.expected || attempts != test.attempts {
+				t.Fatalf("expected '%t' after %d attempts, got '%t' after %d", test.expected, test.attempts, actual, attempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package runner
+
+import (
+	"context"
+	"github.com/jsanc623/ServerStatusEmitter/collector"
+	"github.com/jsanc623/ServerStatusEmitter/config"
+	"time"
+)
+
+// Scheduler runs every enabled collector at its own interval and
+// merges the latest result of each collector into snapshots, so
+// data which rarely changes is not collected on every tick. The
+// result of a collector which failed to return one is left out.
+type Scheduler struct {
+	// Tick is how often the scheduler must be called, the greatest
+	// common divisor of the collector intervals
+	Tick time.Duration
+
+	intervals map[string]time.Duration
+	lastRun   map[string]time.Time
+	latest    map[string]collector.Collector
+	collected map[string]time.Time
+	deriver   *deriver
+}
+
+// NewScheduler returns a Scheduler for the collectors enabled in the configuration
+func NewScheduler() *Scheduler {
+	scheduler := &Scheduler{
+		intervals: make(map[string]time.Duration),
+		lastRun:   make(map[string]time.Time),
+		latest:    make(map[string]collector.Collector),
+		collected: make(map[string]time.Time),
+		deriver:   newDeriver(),
+	}
+
+	conf := config.Current()
+	for _, name := range collector.Names() {
+		if !conf.CollectorEnabled(name) {
+			continue
+		}
+
+		interval := conf.CollectorInterval(name)
+		scheduler.intervals[name] = interval
+		scheduler.Tick = gcd(scheduler.Tick, interval)
+	}
+
+	if scheduler.Tick <= 0 {
+		scheduler.Tick = conf.CollectorInterval("")
+	}
+
+	return scheduler
+}
+
+// Snapshot runs the collectors which are due at now and returns a
+// Snapshot merging their results with the latest results of the
+// collectors which are not due, along with the time they were collected
+// at. It returns nil if no collector was due.
+// The rates derived from the counters are included, the counters
+// themselves, or both, as settings.reporting.counters tells.
+func (Scheduler *Scheduler) Snapshot(ctx context.Context, now time.Time) *Snapshot {
+	var due []string
+	for name, interval := range Scheduler.intervals {
+		// allow half a tick of slack so ticker jitter never skips a run
+		if lastRun, ran := Scheduler.lastRun[name]; !ran || now.Sub(lastRun) >= interval-Scheduler.Tick/2 {
+			due = append(due, name)
+			Scheduler.lastRun[name] = now
+		}
+	}
+
+	if len(due) == 0 {
+		return nil
+	}
+
+	snapshot := &Snapshot{SchemaVersion: SchemaVersion}
+	for _, result := range collectAll(ctx, due) {
+		if result.collector != nil {
+			Scheduler.latest[result.name] = result.collector
+			Scheduler.collected[result.name] = now
+		} else {
+			delete(Scheduler.latest, result.name)
+		}
+
+		// the counters of a failed run may be partial, no rate is derived from them
+		if result.err == nil {
+			Scheduler.deriver.update(result.collector, now)
+		} else {
+			Scheduler.deriver.forget(result.name)
+		}
+		snapshot.fail(result)
+	}
+
+	// the rates of the collectors which are no longer enabled are dropped
+	for name := range Scheduler.deriver.previous {
+		if _, enabled := Scheduler.intervals[name]; !enabled {
+			Scheduler.deriver.forget(name)
+		}
+	}
+
+	for name, c := range Scheduler.latest {
+		snapshot.add(c)
+		if at := Scheduler.collected[name]; !at.Equal(now) {
+			if snapshot.CollectedAt == nil {
+				snapshot.CollectedAt = make(map[string]time.Time)
+			}
+			snapshot.CollectedAt[name] = at.UTC()
+		}
+	}
+
+	snapshot.merge()
+
+	switch config.Current().Settings.Reporting.Counters {
+	case countersRaw:
+	case countersDerived:
+		snapshot.Derived = Scheduler.deriver.snapshot()
+		snapshot.dropCounters()
+	default: // both
+		snapshot.Derived = Scheduler.deriver.snapshot()
+	}
+
+	snapshot.Time = now.UTC()
+	latest.Store(snapshot)
+	return snapshot
+}
+
+// gcd returns the greatest common divisor of two durations
+func gcd(a time.Duration, b time.Duration) time.Duration {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
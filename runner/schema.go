@@ -0,0 +1,117 @@
+package runner
+
+import (
+	"encoding/json"
+	"github.com/jsanc623/ServerStatusEmitter/collector"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// jsonSchemaDraft is the JSON Schema dialect of the published schema
+const jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOverrides return the schemas of the types whose JSON is not the one of their fields
+var schemaOverrides = map[reflect.Type]func() map[string]interface{}{
+	reflect.TypeOf(collector.LoadAvgStat{}): func() map[string]interface{} {
+		return map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "minItems": 3, "maxItems": 3}
+	},
+}
+
+// JSONSchema returns the JSON Schema of a Snapshot, generated from its
+// types and their JSON tags. It is published in schema/snapshot.schema.json.
+func JSONSchema() ([]byte, error) {
+	schema := schemaOf(reflect.TypeOf(Snapshot{}))
+	schema["$schema"] = jsonSchemaDraft
+	schema["title"] = "Snapshot"
+	schema["description"] = "A snapshot of the system, as reported in the node list of every batch"
+	schema["properties"].(map[string]interface{})["schema_version"] = map[string]interface{}{"const": SchemaVersion}
+
+	jsonStr, err := json.MarshalIndent(schema, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return append(jsonStr, '\n'), nil
+}
+
+// schemaOf returns the JSON Schema of the values of type t, as encoding/json
+// marshals them: nil pointers, slices and maps are null, and interfaces any value.
+func schemaOf(t reflect.Type) map[string]interface{} {
+	if override, exists := schemaOverrides[t]; exists {
+		return override()
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return nullable(schemaOf(t.Elem()))
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Slice:
+		return nullable(map[string]interface{}{"type": "array", "items": schemaOf(t.Elem())})
+	case reflect.Map:
+		return nullable(map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem())})
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+	}
+
+	properties := make(map[string]interface{})
+	required := make([]string, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		name := tag[0]
+		if name == "-" {
+			continue
+		} else if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = schemaOf(field.Type)
+		if !contains(tag[1:], "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+// nullable lets a schema match null too
+func nullable(schema map[string]interface{}) map[string]interface{} {
+	if kind, ok := schema["type"].(string); ok {
+		schema["type"] = []string{kind, "null"}
+	}
+	return schema
+}
+
+// contains tells us if list holds value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
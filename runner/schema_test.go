@@ -0,0 +1,118 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/collector"
+	"io/ioutil"
+	"testing"
+)
+
+func TestJSONSchema(t *testing.T) {
+	expected, err := ioutil.ReadFile("../schema/snapshot.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Fatalf("schema/snapshot.schema.json is out of date, regenerate it with `sse schema`")
+	}
+}
+
+func TestSnapshot_Sample(t *testing.T) {
+	samples := make(map[string]map[string]json.RawMessage)
+	for _, name := range []string{"raw", "saveable"} {
+		jsonStr, err := ioutil.ReadFile("../samples/sample_" + name + ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var sample struct {
+			Node []map[string]json.RawMessage `json:"node"`
+		}
+		if err = json.Unmarshal(jsonStr, &sample); err != nil {
+			t.Fatal(err)
+		}
+		samples[name] = sample.Node[0]
+	}
+
+	// Every collector of the documented payload decodes into its type, without
+	// a field left over. The CPU is only documented unmerged in the raw sample.
+	tests := []struct {
+		sample string
+		key    string
+		value  interface{}
+	}{
+		{"raw", "cpu", &collector.CPU{}},
+		{"saveable", "disks", &collector.Disks{}},
+		{"saveable", "memory", &collector.Memory{}},
+		{"saveable", "network", &collector.Network{}},
+		{"saveable", "system", &collector.System{}},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			decoder := json.NewDecoder(bytes.NewReader(samples[test.sample][test.key]))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(test.value); err != nil {
+				t.Fatalf("expected '%s' to decode, got '%s'", test.key, err)
+			}
+		})
+	}
+}
+
+// lookup returns the value at a path of keys in a decoded JSON document
+func lookup(document interface{}, path ...string) interface{} {
+	for _, key := range path {
+		object, ok := document.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		document = object[key]
+	}
+	return document
+}
+
+func TestJSONSchema_Versions(t *testing.T) {
+	jsonStr, err := JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schema interface{}
+	if err = json.Unmarshal(jsonStr, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every schema version is the change of the snapshots it was bumped for
+	tests := []struct {
+		version  int
+		path     []string
+		expected string
+	}{
+		{2, []string{"properties", "schema_version", "const"}, fmt.Sprint(SchemaVersion)},
+		{3, []string{"properties", "derived", "type"}, "[object null]"},
+		{4, []string{"properties", "processes", "type"}, "[object null]"},
+		{5, []string{"properties", "watch", "type"}, "[object null]"},
+		{6, []string{"properties", "system", "properties", "load_avg", "items", "type"}, "string"},
+		{6, []string{"properties", "network", "properties", "interface", "items", "properties", "addrs", "items", "type"}, "string"},
+		{7, []string{"properties", "collected_at", "additionalProperties", "format"}, "date-time"},
+	}
+
+	if latest := tests[len(tests)-1].version; latest != SchemaVersion {
+		t.Fatalf("expected the change of version %d to be tested, got version %d last", SchemaVersion, latest)
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			if actual := fmt.Sprint(lookup(schema, test.path...)); actual != test.expected {
+				t.Fatalf("expected '%s', got '%s'", test.expected, actual)
+			}
+		})
+	}
+}
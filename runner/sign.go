@@ -0,0 +1,133 @@
+package runner
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The headers authenticating the requests made to the mothership
+const (
+	IDHeader        = "X-Sse-Id"
+	TimestampHeader = "X-Sse-Timestamp"
+	NonceHeader     = "X-Sse-Nonce"
+	SignatureHeader = "X-Sse-Signature"
+)
+
+var (
+	ErrUnsigned      = errors.New("the request is not signed")
+	ErrBadTimestamp  = errors.New("the request timestamp is not in RFC 3339")
+	ErrClockSkew     = errors.New("the request timestamp is outside of the clock skew window")
+	ErrBadSignature  = errors.New("the request signature does not match")
+	ErrReplayedNonce = errors.New("the request nonce was already used")
+)
+
+// Sign adds the headers authenticating a request to the mothership: the
+// id of the emitter, the time of the request in RFC 3339, a random nonce,
+// and the signature of the request under key. body is the request body as
+// it is sent, compressed or not. Requests are left unsigned without a key.
+func Sign(req *http.Request, body []byte, id string, key string) error {
+	return signAt(req, body, id, key, time.Now())
+}
+
+// signAt is Sign at the time now
+func signAt(req *http.Request, body []byte, id string, key string, now time.Time) error {
+	if key == "" {
+		return nil
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(random)
+	timestamp := now.UTC().Format(time.RFC3339)
+
+	req.Header.Set(IDHeader, id)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(NonceHeader, nonce)
+	req.Header.Set(SignatureHeader, signature(key, req.Method, req.URL.RequestURI(), timestamp, nonce, body))
+	return nil
+}
+
+// signature returns the hex encoded HMAC-SHA256 under key of the method,
+// the path and query, the timestamp, the nonce and the hex encoded SHA-256
+// of the body, one per line.
+func signature(key string, method string, uri string, timestamp string, nonce string, body []byte) string {
+	hash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, []byte(key))
+	_, _ = mac.Write([]byte(method + "\n" + uri + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(hash[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyRequest checks, on the mothership side, that a request was signed
+// with key less than skew away from now, and that its nonce was not used
+// before. body is the request body as it was received.
+func VerifyRequest(req *http.Request, body []byte, key string, skew time.Duration, nonces *NonceCache) error {
+	return verifyRequest(req, body, key, skew, nonces, time.Now())
+}
+
+// verifyRequest is VerifyRequest at the time now
+func verifyRequest(req *http.Request, body []byte, key string, skew time.Duration, nonces *NonceCache, now time.Time) error {
+	timestamp := req.Header.Get(TimestampHeader)
+	nonce := req.Header.Get(NonceHeader)
+	signed := req.Header.Get(SignatureHeader)
+	if timestamp == "" || nonce == "" || signed == "" {
+		return ErrUnsigned
+	}
+
+	at, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return ErrBadTimestamp
+	}
+	if at.Before(now.Add(-skew)) || at.After(now.Add(skew)) {
+		return ErrClockSkew
+	}
+
+	expected := signature(key, req.Method, req.URL.RequestURI(), timestamp, nonce, body)
+	if !hmac.Equal([]byte(signed), []byte(expected)) {
+		return ErrBadSignature
+	}
+
+	// The nonce is remembered as long as its timestamp is accepted
+	if !nonces.add(nonce, at.Add(skew), now) {
+		return ErrReplayedNonce
+	}
+	return nil
+}
+
+// NonceCache remembers the nonces of the verified requests until their
+// timestamp falls out of the clock skew window, so they cannot be replayed.
+type NonceCache struct {
+	mutex   sync.Mutex
+	expires map[string]time.Time
+}
+
+// NewNonceCache returns an empty NonceCache
+func NewNonceCache() *NonceCache {
+	return &NonceCache{expires: make(map[string]time.Time)}
+}
+
+// add remembers a nonce until expires, it returns false if the nonce is already known
+func (NonceCache *NonceCache) add(nonce string, expires time.Time, now time.Time) bool {
+	NonceCache.mutex.Lock()
+	defer NonceCache.mutex.Unlock()
+
+	for known, expiry := range NonceCache.expires {
+		if now.After(expiry) {
+			delete(NonceCache.expires, known)
+		}
+	}
+
+	if _, exists := NonceCache.expires[nonce]; exists {
+		return false
+	}
+	NonceCache.expires[nonce] = expires
+	return true
+}
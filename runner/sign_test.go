@@ -0,0 +1,134 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVerifyRequest(t *testing.T) {
+	body := []byte(`{"node":[]}`)
+	signedRequest := func() *http.Request {
+		req, err := http.NewRequest("POST", "https://mothership.example.com/api/1.0/collector", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = Sign(req, body, "account", "secret"); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+
+	replayed := signedRequest()
+	nonces := NewNonceCache()
+	if err := VerifyRequest(replayed, body, "secret", time.Minute, nonces); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		modify   func(req *http.Request) []byte
+		now      time.Time
+		expected error
+	}{
+		{func(req *http.Request) []byte { return body }, time.Now(), nil},
+		{func(req *http.Request) []byte { return []byte(`{"node":[{}]}`) }, time.Now(), ErrBadSignature},
+		{func(req *http.Request) []byte {
+			req.Header.Set(SignatureHeader, signature("other", req.Method, req.URL.RequestURI(), req.Header.Get(TimestampHeader), req.Header.Get(NonceHeader), body))
+			return body
+		}, time.Now(), ErrBadSignature},
+		{func(req *http.Request) []byte {
+			req.URL.Path = "/api/1.0/register"
+			return body
+		}, time.Now(), ErrBadSignature},
+		{func(req *http.Request) []byte { return body }, time.Now().Add(2 * time.Minute), ErrClockSkew},
+		{func(req *http.Request) []byte { return body }, time.Now().Add(-2 * time.Minute), ErrClockSkew},
+		{func(req *http.Request) []byte {
+			req.Header.Set(TimestampHeader, time.Now().UTC().String())
+			return body
+		}, time.Now(), ErrBadTimestamp},
+		{func(req *http.Request) []byte {
+			req.Header.Del(SignatureHeader)
+			return body
+		}, time.Now(), ErrUnsigned},
+		{func(req *http.Request) []byte {
+			*req = *replayed
+			return body
+		}, time.Now(), ErrReplayedNonce},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			req := signedRequest()
+			received := test.modify(req)
+
+			if err := verifyRequest(req, received, "secret", time.Minute, nonces, test.now); err != test.expected {
+				t.Fatalf("expected '%v', got '%v'", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestNonceCache_Add(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	nonces := NewNonceCache()
+
+	tests := []struct {
+		nonce    string
+		now      time.Time
+		expected bool
+	}{
+		{"a", now, true},
+		{"a", now.Add(time.Minute), false},
+		{"b", now.Add(time.Minute), true},
+		{"a", now.Add(3 * time.Minute), true}, // expired, it can no longer pass the clock skew check
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			if actual := nonces.add(test.nonce, test.now.Add(2*time.Minute), test.now); actual != test.expected {
+				t.Fatalf("expected '%t', got '%t'", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSendOnce_Time(t *testing.T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		key    string
+		signed bool
+	}{
+		{"", false},
+		{"secret", true},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			conf := testConfig(t)
+			conf.Identification.Key = test.key
+			config.Store(&conf)
+			defer config.Store(&config.Config{})
+
+			if _, _, err := sendOnce(context.Background(), server.URL, []byte(`{"node":[]}`)); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := time.Parse(time.RFC3339, header.Get("X-Sse-Time")); err != nil {
+				t.Fatalf("expected the time in RFC 3339, got '%s'", header.Get("X-Sse-Time"))
+			}
+			if test.signed && header.Get("X-Sse-Time") != header.Get(TimestampHeader) {
+				t.Fatalf("expected '%s', got '%s'", header.Get(TimestampHeader), header.Get("X-Sse-Time"))
+			}
+		})
+	}
+}
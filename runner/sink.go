@@ -0,0 +1,235 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/config"
+	error2 "github.com/jsanc623/ServerStatusEmitter/sphlog"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink is a destination Cache batches are reported to, such as the
+// mothership. Every configured Sink receives every batch, and is
+// retried and spooled independently of the others.
+type Sink interface {
+	// Name returns the name the sink is configured under
+	Name() string
+
+	// Configure applies the options given to the sink in the configuration
+	Configure(options map[string]interface{}) error
+
+	// Send makes a single attempt at delivering the batch, a failed
+	// attempt is retried unless it returns a SinkError telling otherwise
+	Send(ctx context.Context, cache *Cache) error
+}
+
+// StatusChecker is implemented by the sinks which can tell whether their
+// destination is up, which is checked before their spool is replayed
+type StatusChecker interface {
+	CheckStatus() error
+}
+
+// SinkFactory returns a new, unconfigured, Sink with the given name
+type SinkFactory func(name string) Sink
+
+// SinkError is a failed Send which tells whether it is worth retrying
+// and how long the destination asked us to wait before doing so.
+type SinkError struct {
+	Err   error
+	Retry bool
+	Wait  time.Duration
+}
+
+// Error returns the error of the failed Send
+func (SinkError *SinkError) Error() string {
+	return SinkError.Err.Error()
+}
+
+// defaultSink is the type of the sink used when none is configured
+const defaultSink = "mothership"
+
+var (
+	sinkMutex    sync.RWMutex
+	sinkRegistry = make(map[string]SinkFactory)
+)
+
+func init() {
+	// Validate the sinks section of the configuration against the registered sinks
+	config.KnownSinks = SinkTypes
+}
+
+// RegisterSink makes a sink available under the given type.
+// It panics if the type is registered twice.
+func RegisterSink(kind string, factory SinkFactory) {
+	sinkMutex.Lock()
+	defer sinkMutex.Unlock()
+
+	if _, exists := sinkRegistry[kind]; exists {
+		panic("runner: RegisterSink called twice for " + kind)
+	}
+	sinkRegistry[kind] = factory
+}
+
+// NewSink returns a new instance of the sink registered under kind
+func NewSink(kind string, name string) (Sink, error) {
+	sinkMutex.RLock()
+	factory, exists := sinkRegistry[kind]
+	sinkMutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("unknown sink %q", kind)
+	}
+	return factory(name), nil
+}
+
+// SinkTypes returns the sorted types of all registered sinks
+func SinkTypes() []string {
+	sinkMutex.RLock()
+	defer sinkMutex.RUnlock()
+
+	kinds := make([]string, 0, len(sinkRegistry))
+	for kind := range sinkRegistry {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Output is a configured Sink, with its own retry policy and spool
+type Output struct {
+	Sink   Sink
+	policy retryPolicy
+	spool  *Spool
+}
+
+// NewOutputs returns the outputs of the sinks listed in the configuration,
+// or of a single mothership sink if none are. Each sink spools into its
+// own directory under the spool directory, except the default mothership
+// sink which keeps using the spool directory itself, and gets an even
+// share of the spool size cap.
+func NewOutputs(conf *config.Config) ([]*Output, error) {
+	spool := conf.Settings.Reporting.Spool
+	maxAge := time.Duration(spool.MaxAgeSeconds) * time.Second
+
+	if len(conf.Settings.Sinks) == 0 {
+		sink, err := NewSink(defaultSink, defaultSink)
+		if err != nil {
+			return nil, err
+		}
+
+		output := &Output{Sink: sink, policy: newRetryPolicy()}
+		if spool.Directory != "" {
+			if output.spool, err = NewSpool(spool.Directory, spool.MaxBytes, maxAge); err != nil {
+				return nil, err
+			}
+		}
+		return []*Output{output}, nil
+	}
+
+	maxBytes := spool.MaxBytes / int64(len(conf.Settings.Sinks))
+	if spool.MaxBytes > 0 && maxBytes == 0 {
+		maxBytes = 1
+	}
+
+	var outputs []*Output
+	for index, settings := range conf.Settings.Sinks {
+		name := conf.SinkName(index)
+
+		sink, err := NewSink(settings.Type, name)
+		if err != nil {
+			return nil, err
+		}
+		if err = sink.Configure(settings.Options); err != nil {
+			return nil, fmt.Errorf("sink %s: %s", name, err)
+		}
+
+		retry := settings.Retry
+		output := &Output{
+			Sink:   sink,
+			policy: newRetryPolicy().override(retry.MaxAttempts, retry.BaseDelayMilliseconds, retry.MaxDelayMilliseconds, retry.Jitter),
+		}
+		if spool.Directory != "" {
+			if output.spool, err = NewSpool(filepath.Join(spool.Directory, name), maxBytes, maxAge); err != nil {
+				return nil, err
+			}
+		}
+		outputs = append(outputs, output)
+	}
+
+	return outputs, nil
+}
+
+// Deliver reports the Cache to every output concurrently: spooled batches
+// are replayed first, once the destination is up for the sinks which can
+// tell, and the Cache is spooled for the outputs it cannot be delivered to. The
+// Node cache is cleared afterwards. It returns an error naming the
+// outputs the Cache could be neither delivered to nor spooled for.
+func (Cache *Cache) Deliver(ctx context.Context, outputs []*Output) error {
+	defer func() {
+		Cache.Node = nil // Clear the Node Cache
+	}()
+
+	lost := make([]string, len(outputs))
+	var wg sync.WaitGroup
+	for index, output := range outputs {
+		wg.Add(1)
+		go func(index int, output *Output) {
+			defer wg.Done()
+			if err := output.flush(ctx, Cache); err != nil {
+				error2.LogError(err)
+				lost[index] = output.Sink.Name()
+			}
+		}(index, output)
+	}
+	wg.Wait()
+
+	var names []string
+	for _, name := range lost {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		return fmt.Errorf("lost %d snapshots the sinks %s did not receive", len(Cache.Node), strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// flush replays the spool of the output, then delivers the Cache or spools it
+func (Output *Output) flush(ctx context.Context, cache *Cache) error {
+	if Output.spool == nil {
+		return Output.deliver(ctx, cache)
+	}
+
+	var check func() error
+	if checker, ok := Output.Sink.(StatusChecker); ok {
+		check = checker.CheckStatus
+	}
+
+	err := Output.spool.ReplayTo(check, func(spooled *Cache) error {
+		return Output.deliver(ctx, spooled)
+	})
+	if err == nil {
+		if err = Output.deliver(ctx, cache); err == nil {
+			return nil
+		}
+	}
+	error2.LogError(err)
+
+	return Output.spool.Store(cache)
+}
+
+// deliver sends the Cache to the sink, retrying it according to the output retry policy
+func (Output *Output) deliver(ctx context.Context, cache *Cache) error {
+	return Output.policy.do(ctx, "report to sink "+Output.Sink.Name(), func() (bool, time.Duration, error) {
+		err := Output.Sink.Send(ctx, cache)
+		if sinkError, ok := err.(*SinkError); ok {
+			return sinkError.Retry, sinkError.Wait, err
+		}
+		return true, 0, err
+	})
+}
@@ -0,0 +1,204 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/collector"
+	"github.com/jsanc623/ServerStatusEmitter/config"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCache_Deliver(t *testing.T) {
+	up := false
+	var received int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received++
+	}))
+	defer server.Close()
+
+	directory, err := ioutil.TempDir("", "sse-sinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(directory)
+	}()
+
+	var conf config.Config
+	err = json.Unmarshal([]byte(fmt.Sprintf(`{"settings": {
+		"reporting": {"spool": {"directory": %q}},
+		"sinks": [
+			{"type": "file", "name": "archive", "options": {"path": %q}},
+			{"type": "mothership", "options": {"url": %q}, "retry": {"max_attempts": 1}}
+		]
+	}}`, directory, filepath.Join(directory, "archive.json"), server.URL)), &conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Store(&conf)
+	defer config.Store(&config.Config{})
+
+	outputs, err := NewOutputs(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		up       bool
+		received int
+		pending  int
+		archived int
+	}{
+		{false, 0, 1, 1},
+		{true, 2, 0, 2},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			up = test.up
+			cache := Cache{Node: []*Snapshot{{}}}
+			if err := cache.Deliver(context.Background(), outputs); err != nil {
+				t.Fatal(err)
+			}
+
+			if received != test.received {
+				t.Fatalf("expected '%d', got '%d'", test.received, received)
+			}
+			if pending := pending(t, outputs[1].spool); pending != test.pending {
+				t.Fatalf("expected '%d', got '%d'", test.pending, pending)
+			}
+
+			archive, err := ioutil.ReadFile(filepath.Join(directory, "archive.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if archived := strings.Count(string(archive), "\n"); archived != test.archived {
+				t.Fatalf("expected '%d', got '%d'", test.archived, archived)
+			}
+		})
+	}
+}
+
+func TestNewOutputs(t *testing.T) {
+	tests := []struct {
+		sinks    string
+		expected string
+	}{
+		{`[]`, "[mothership]"},
+		{`[{"type": "stdout"}, {"type": "file", "name": "archive", "options": {"path": "archive.json"}}]`, "[stdout archive]"},
+		{`[{"type": "file"}]`, `sink file: option "path" is required`},
+		{`[{"type": "stdout", "options": {"pretty": true}}]`, `sink stdout: unknown option "pretty"`},
+		{`[{"type": "carrier-pigeon"}]`, `unknown sink "carrier-pigeon"`},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			var conf config.Config
+			if err := json.Unmarshal([]byte(`{"settings": {"sinks": `+test.sinks+`}}`), &conf); err != nil {
+				t.Fatal(err)
+			}
+
+			outputs, err := NewOutputs(&conf)
+			actual := fmt.Sprint(err)
+			if err == nil {
+				var names []string
+				for _, output := range outputs {
+					names = append(names, output.Sink.Name())
+				}
+				actual = fmt.Sprint(names)
+			}
+
+			if actual != test.expected {
+				t.Fatalf("expected '%s', got '%s'", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestStdoutSink_Send(t *testing.T) {
+	var buffer bytes.Buffer
+	stdout = &buffer
+	defer func() {
+		stdout = os.Stdout
+	}()
+
+	sink, err := NewSink("stdout", "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := Cache{ID: "test-id", Node: []*Snapshot{{
+		Custom: map[string]collector.Collector{"test-ok": &testCollector{name: "test-ok", Value: "collected"}},
+	}}}
+	if err = sink.Send(context.Background(), &cache); err != nil {
+		t.Fatal(err)
+	}
+
+	// the custom collectors survive being read back, such as from the spool
+	var actual Cache
+	if err = json.Unmarshal(buffer.Bytes(), &actual); err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := actual.Node[0].Custom["test-ok"].(*testCollector); !ok || c.Value != "collected" {
+		t.Fatalf("expected 'collected', got '%v'", actual.Node[0].Custom)
+	}
+}
+
+func TestNewOutputs_SpoolShare(t *testing.T) {
+	directory, err := ioutil.TempDir("", "sse-sinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(directory)
+	}()
+
+	tests := []struct {
+		maxBytes int64
+		sinks    string
+		expected string
+	}{
+		{0, `[{"type": "stdout"}, {"type": "mothership"}]`, "[0 0]"},
+		{10, `[{"type": "stdout"}]`, "[10]"},
+		{10, `[{"type": "stdout"}, {"type": "mothership"}]`, "[5 5]"},
+		{2, `[{"type": "stdout"}, {"type": "mothership"}, {"type": "stdout", "name": "copy"}]`, "[1 1 1]"},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			var conf config.Config
+			err := json.Unmarshal([]byte(fmt.Sprintf(`{"settings": {
+				"reporting": {"spool": {"directory": %q, "max_bytes": %d}},
+				"sinks": %s
+			}}`, directory, test.maxBytes, test.sinks)), &conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			outputs, err := NewOutputs(&conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var shares []int64
+			for _, output := range outputs {
+				shares = append(shares, output.spool.MaxBytes)
+			}
+			if actual := fmt.Sprint(shares); actual != test.expected {
+				t.Fatalf("expected '%s', got '%s'", test.expected, actual)
+			}
+		})
+	}
+}
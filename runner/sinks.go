@@ -0,0 +1,149 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/collector"
+	"github.com/jsanc623/ServerStatusEmitter/config"
+	"github.com/jsanc623/ServerStatusEmitter/helper"
+	"io"
+	"os"
+	"sync"
+)
+
+// stdout is where the stdout sink writes, replaced in tests
+var stdout io.Writer = os.Stdout
+
+func init() {
+	RegisterSink("mothership", func(name string) Sink { return &MothershipSink{name: name} })
+	RegisterSink("file", func(name string) Sink { return &FileSink{name: name} })
+	RegisterSink("stdout", func(name string) Sink { return &StdoutSink{name: name} })
+}
+
+// MothershipSink posts batches to the collector URL of the mothership
+type MothershipSink struct {
+	name string
+
+	// URL overrides the collector URL of the configured mothership
+	URL string
+}
+
+// Name returns the name the sink is configured under
+func (MothershipSink *MothershipSink) Name() string {
+	return MothershipSink.name
+}
+
+// Configure reads the url option
+func (MothershipSink *MothershipSink) Configure(options map[string]interface{}) error {
+	url, err := collector.StringOption(options, "url", "")
+	MothershipSink.URL = url
+	return err
+}
+
+// Send posts the Cache to the mothership
+func (MothershipSink *MothershipSink) Send(ctx context.Context, cache *Cache) error {
+	jsonStr, err := cache.Encode()
+	if err != nil {
+		return &SinkError{Err: err}
+	}
+
+	url := MothershipSink.URL
+	if url == "" {
+		url = config.Current().GetCollectorURL()
+	}
+
+	retry, wait, err := sendOnce(ctx, url, jsonStr)
+	if err != nil {
+		return &SinkError{Err: err, Retry: retry, Wait: wait}
+	}
+	return nil
+}
+
+// CheckStatus checks the status of the configured mothership. A sink
+// posting to another URL has no known status URL, and is not checked.
+func (MothershipSink *MothershipSink) CheckStatus() error {
+	if MothershipSink.URL != "" {
+		return nil
+	}
+	return helper.CheckStatus(config.Current().GetStatusURL())
+}
+
+// FileSink appends every batch to a file, one JSON document per line
+type FileSink struct {
+	name  string
+	mutex sync.Mutex
+
+	// Path is the file the batches are appended to
+	Path string
+}
+
+// Name returns the name the sink is configured under
+func (FileSink *FileSink) Name() string {
+	return FileSink.name
+}
+
+// Configure reads the required path option
+func (FileSink *FileSink) Configure(options map[string]interface{}) error {
+	path, err := collector.StringOption(options, "path", "")
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		return errors.New(`option "path" is required`)
+	}
+
+	FileSink.Path = path
+	return nil
+}
+
+// Send appends the Cache to the file
+func (FileSink *FileSink) Send(ctx context.Context, cache *Cache) error {
+	jsonStr, err := cache.Encode()
+	if err != nil {
+		return &SinkError{Err: err}
+	}
+
+	FileSink.mutex.Lock()
+	defer FileSink.mutex.Unlock()
+
+	file, err := os.OpenFile(FileSink.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(append(jsonStr, '\n')); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// StdoutSink writes every batch to the standard output, one JSON document per line
+type StdoutSink struct {
+	name string
+}
+
+// Name returns the name the sink is configured under
+func (StdoutSink *StdoutSink) Name() string {
+	return StdoutSink.name
+}
+
+// Configure accepts no options
+func (StdoutSink *StdoutSink) Configure(options map[string]interface{}) error {
+	for key := range options {
+		return fmt.Errorf("unknown option %q", key)
+	}
+	return nil
+}
+
+// Send writes the Cache to the standard output
+func (StdoutSink *StdoutSink) Send(ctx context.Context, cache *Cache) error {
+	jsonStr, err := cache.Encode()
+	if err != nil {
+		return &SinkError{Err: err}
+	}
+
+	_, err = stdout.Write(append(jsonStr, '\n'))
+	return err
+}
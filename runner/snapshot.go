@@ -1,56 +1,382 @@
 package runner
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/jsanc623/ServerStatusEmitter/collector"
 	"github.com/jsanc623/ServerStatusEmitter/config"
 	error2 "github.com/jsanc623/ServerStatusEmitter/sphlog"
+	"reflect"
+	"sort"
+	"sync"
 	"time"
 )
 
-var Conf config.Config
+// SchemaVersion is the version of the Snapshot JSON schema published in
+// schema/snapshot.schema.json. Version 1 was the untyped payload, whose
+// keys were the Go field names of the collectors and of gopsutil.
+// Version 2 had no derived rates, version 3 had no processes, version 4
+// had no watched processes, version 5 serialized the load averages and
+// the interface addresses as objects instead of strings, and version 6
+// did not tell the collectors merged from an earlier run apart.
+const SchemaVersion = 7
+
+func init() {
+	// Validate the collectors section of the configuration against the registered collectors
+	config.KnownCollectors = collector.Names
+}
 
 // Snapshot struct is a collection of other structs
 // which are relayed from the different segments of
 // the collector package.
 type Snapshot struct {
-	CPU     *collector.CPU
-	Disks   *collector.Disks
-	Memory  *collector.Memory
-	Network *collector.Network
-	System  *collector.System
-	Time    time.Time
+	SchemaVersion int `json:"schema_version"`
+
+	CPU     *collector.CPU     `json:"cpu"`
+	Disks   *collector.Disks   `json:"disks"`
+	Memory  *collector.Memory  `json:"memory"`
+	Network *collector.Network `json:"network"`
+	System  *collector.System  `json:"system"`
+
+	// CPUInfo, HostInfo and DiskPartitions are the static information collected
+	// apart from the CPU, System and Disks, and reported within them, see merge
+	CPUInfo        *collector.CPUInfo        `json:"-"`
+	HostInfo       *collector.HostInfo       `json:"-"`
+	DiskPartitions *collector.DiskPartitions `json:"-"`
+
+	// Processes is only collected once the processes collector is enabled
+	Processes *collector.Processes `json:"processes,omitempty"`
+
+	// Watch is only collected once processes are watched
+	Watch *collector.Watch `json:"watch,omitempty"`
+
+	// Custom holds the collectors registered outside of the built-in ones, by name
+	Custom map[string]collector.Collector `json:"custom,omitempty"`
+
+	// Derived holds the rates computed from the counters of the collectors
+	Derived *Derived `json:"derived,omitempty"`
+
+	// CollectedAt holds the time of the earlier run of the collectors which
+	// were not due, by name, whose latest result is merged into the Snapshot
+	CollectedAt map[string]time.Time `json:"collected_at,omitempty"`
+
+	// Errors lists the collectors which failed or timed out, their segment may be missing or partial
+	Errors []CollectorError `json:"errors,omitempty"`
+	Time   time.Time        `json:"system_time"`
+}
+
+// CollectorError tells the mothership why a collector is missing from a Snapshot
+type CollectorError struct {
+	Collector string `json:"collector"`
+	Error     string `json:"error"`
+	TimedOut  bool   `json:"timed_out"`
+}
+
+// errStillRunning is the error of a collector whose previous run has not returned yet
+var errStillRunning = errors.New("the previous run is still in progress")
+
+var (
+	// running holds the collectors whose run has not returned yet, by name,
+	// including the ones abandoned at their deadline
+	running      = make(map[string]bool)
+	runningMutex sync.Mutex
+)
+
+// collection is the outcome of a single collector run
+type collection struct {
+	name      string
+	collector collector.Collector
+	err       error
 }
 
 // Collector collects a snapshot of the system at
 // the time of calling and stores it in Snapshot struct.
-func (Snapshot *Snapshot) Collector() {
-	// Initialize collectors
-	var CPU collector.CPU
-	var Disks collector.Disks
-	var Memory collector.Memory
-	var Network collector.Network
-	var System collector.System
+// Every enabled collector runs concurrently under its
+// own deadline, and the collectors which failed or
+// timed out are recorded in the Snapshot.
+func (Snapshot *Snapshot) Collector(ctx context.Context) {
+	conf := config.Current()
 
-	// Perform collection runs
-	err := CPU.Collect()
-	error2.LogError(err)
+	var names []string
+	for _, name := range collector.Names() {
+		if conf.CollectorEnabled(name) {
+			names = append(names, name)
+		}
+	}
 
-	err = Disks.Collect(Conf.Settings.Disk.IncludePartitionData)
-	error2.LogError(err)
+	for _, result := range collectAll(ctx, names) {
+		if result.collector != nil {
+			Snapshot.add(result.collector)
+		}
+		Snapshot.fail(result)
+	}
+	Snapshot.merge()
 
-	err = Memory.Collect()
-	error2.LogError(err)
+	Snapshot.SchemaVersion = SchemaVersion
+	Snapshot.Time = time.Now().UTC()
+}
 
-	err = Network.Collect()
-	error2.LogError(err)
+// collectAll runs the named collectors concurrently
+func collectAll(ctx context.Context, names []string) []collection {
+	collections := make([]collection, len(names))
 
-	err = System.Collect(Conf.Settings.System.IncludeUsers)
-	error2.LogError(err)
+	var wg sync.WaitGroup
+	for index, name := range names {
+		wg.Add(1)
+		go func(index int, name string) {
+			defer wg.Done()
+			collections[index] = collect(ctx, name)
+		}(index, name)
+	}
+	wg.Wait()
 
-	Snapshot.Time = time.Now().UTC()
-	Snapshot.CPU = &CPU
-	Snapshot.Disks = &Disks
-	Snapshot.Memory = &Memory
-	Snapshot.Network = &Network
-	Snapshot.System = &System
+	return collections
+}
+
+// collect runs the named collector until it is done or its deadline passes.
+// A collector which did not finish in time is abandoned and not returned,
+// since it may still be writing to its fields, and it is not run again
+// until that run returns, so that a hung collector does not pile up.
+func collect(ctx context.Context, name string) collection {
+	result := collection{name: name}
+	conf := config.Current()
+
+	// Initialize the collector
+	c, err := collector.New(name)
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	if err = c.Configure(conf.CollectorOptions(name)); err != nil {
+		result.err = err
+		return result
+	}
+
+	// Perform the collection run
+	ctx, cancel := context.WithTimeout(ctx, conf.CollectorTimeout(name))
+	defer cancel()
+
+	if !startRun(name) {
+		result.err = errStillRunning
+		return result
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer endRun(name)
+		done <- c.Collect(ctx)
+	}()
+
+	select {
+	case result.err = <-done:
+		result.collector = c
+	case <-ctx.Done():
+		result.err = ctx.Err()
+	}
+
+	return result
+}
+
+// startRun marks the named collector as running, it returns false if it already is
+func startRun(name string) bool {
+	runningMutex.Lock()
+	defer runningMutex.Unlock()
+
+	if running[name] {
+		return false
+	}
+	running[name] = true
+	return true
+}
+
+// endRun marks the named collector as no longer running
+func endRun(name string) {
+	runningMutex.Lock()
+	defer runningMutex.Unlock()
+
+	delete(running, name)
+}
+
+// fail records a collector which failed or timed out in the Snapshot
+func (Snapshot *Snapshot) fail(result collection) {
+	if result.err == nil {
+		return
+	}
+
+	error2.LogError(fmt.Errorf("collector %s: %s", result.name, result.err))
+	Snapshot.Errors = append(Snapshot.Errors, CollectorError{
+		Collector: result.name,
+		Error:     result.err.Error(),
+		TimedOut:  result.err == context.DeadlineExceeded,
+	})
+}
+
+// Metrics returns the metrics of every collector in the Snapshot which can describe its data as metrics
+func (Snapshot *Snapshot) Metrics() []collector.Metric {
+	return Snapshot.metrics(false)
+}
+
+// FreshMetrics is Metrics without the metrics of the collectors merged from
+// an earlier run, which were already reported with the Snapshot of that run
+func (Snapshot *Snapshot) FreshMetrics() []collector.Metric {
+	return Snapshot.metrics(true)
+}
+
+// metrics returns the metrics of the Snapshot, leaving out the ones of the
+// collectors merged from an earlier run when fresh is set
+func (Snapshot *Snapshot) metrics(fresh bool) []collector.Metric {
+	stale := func(name string) bool {
+		_, merged := Snapshot.CollectedAt[name]
+		return fresh && merged
+	}
+
+	collectors := []collector.Collector{Snapshot.CPU, Snapshot.Disks, Snapshot.Memory, Snapshot.Network, Snapshot.System, Snapshot.Processes, Snapshot.Watch}
+
+	names := make([]string, 0, len(Snapshot.Custom))
+	for name := range Snapshot.Custom {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		collectors = append(collectors, Snapshot.Custom[name])
+	}
+
+	var metrics []collector.Metric
+	if Snapshot.Derived != nil {
+		derived := *Snapshot.Derived
+		if stale("cpu") {
+			derived.CPU = nil
+		}
+		if stale("disks") {
+			derived.Disks = nil
+		}
+		if stale("network") {
+			derived.Network = nil
+		}
+		metrics = derived.Metrics()
+	}
+	for _, c := range collectors {
+		// the built-in collectors which did not run are typed nil pointers
+		if value := reflect.ValueOf(c); value.Kind() == reflect.Ptr && value.IsNil() {
+			continue
+		}
+		if stale(c.Name()) {
+			continue
+		}
+		if measurer, ok := c.(collector.Measurer); ok {
+			metrics = append(metrics, measurer.Metrics()...)
+		}
+	}
+	return metrics
+}
+
+// dropCounters removes the cumulative counters the Derived rates are computed
+// from. The collectors may be shared with other snapshots, so they are copied.
+func (Snapshot *Snapshot) dropCounters() {
+	if Snapshot.CPU != nil {
+		cpu := *Snapshot.CPU
+		cpu.Times = nil
+		Snapshot.CPU = &cpu
+	}
+	if Snapshot.Disks != nil {
+		disks := *Snapshot.Disks
+		disks.DiskIOCounters = nil
+		Snapshot.Disks = &disks
+	}
+	if Snapshot.Network != nil {
+		network := *Snapshot.Network
+		network.NetIOCounters = nil
+		Snapshot.Network = &network
+	}
+}
+
+// merge reports the static information within the CPU, System and Disks,
+// where it is documented. It is left out when they were not collected.
+// The collectors may be shared with other snapshots, so they are copied.
+func (Snapshot *Snapshot) merge() {
+	if Snapshot.CPU != nil && Snapshot.CPUInfo != nil {
+		cpu := *Snapshot.CPU
+		cpu.Info = Snapshot.CPUInfo.Info
+		Snapshot.CPU = &cpu
+	}
+	if Snapshot.System != nil && Snapshot.HostInfo != nil && Snapshot.HostInfo.Info != nil {
+		system := *Snapshot.System
+		info := *Snapshot.HostInfo.Info
+		// the uptime and the number of processes are the ones of the System, which are current
+		if system.HostInfo != nil {
+			info.Uptime = system.HostInfo.Uptime
+			info.Procs = system.HostInfo.Procs
+		}
+		system.HostInfo = &info
+		Snapshot.System = &system
+	}
+	if Snapshot.Disks != nil && Snapshot.DiskPartitions != nil {
+		disks := *Snapshot.Disks
+		disks.DiskPartition = Snapshot.DiskPartitions.Partitions
+		Snapshot.Disks = &disks
+	}
+}
+
+// plainSnapshot is a Snapshot without its UnmarshalJSON method, which would recurse forever
+type plainSnapshot Snapshot
+
+// UnmarshalJSON reads a Snapshot back, such as from the spool. Custom
+// collectors are created from the registry, the unknown ones are dropped.
+func (Snapshot *Snapshot) UnmarshalJSON(data []byte) error {
+	raw := struct {
+		*plainSnapshot
+		Custom map[string]json.RawMessage `json:"custom"`
+	}{plainSnapshot: (*plainSnapshot)(Snapshot)}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	Snapshot.Custom = nil
+	for name, jsonStr := range raw.Custom {
+		c, err := collector.New(name)
+		if err != nil {
+			error2.LogWarn(fmt.Sprintf("dropping the %s segment of a snapshot: %s", name, err))
+			continue
+		}
+		if err = json.Unmarshal(jsonStr, c); err != nil {
+			return err
+		}
+		Snapshot.add(c)
+	}
+	return nil
+}
+
+// add stores a collector in its place in the Snapshot
+func (Snapshot *Snapshot) add(c collector.Collector) {
+	switch c := c.(type) {
+	case *collector.CPU:
+		Snapshot.CPU = c
+	case *collector.Disks:
+		Snapshot.Disks = c
+	case *collector.Memory:
+		Snapshot.Memory = c
+	case *collector.Network:
+		Snapshot.Network = c
+	case *collector.System:
+		Snapshot.System = c
+	case *collector.CPUInfo:
+		Snapshot.CPUInfo = c
+	case *collector.HostInfo:
+		Snapshot.HostInfo = c
+	case *collector.DiskPartitions:
+		Snapshot.DiskPartitions = c
+	case *collector.Processes:
+		Snapshot.Processes = c
+	case *collector.Watch:
+		Snapshot.Watch = c
+	default:
+		if Snapshot.Custom == nil {
+			Snapshot.Custom = make(map[string]collector.Collector)
+		}
+		Snapshot.Custom[c.Name()] = c
+	}
 }
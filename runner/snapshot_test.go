@@ -0,0 +1,316 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/collector"
+	"github.com/jsanc623/ServerStatusEmitter/config"
+	"testing"
+	"time"
+)
+
+// testCollector is a custom collector which hangs until its deadline, or
+// until it is released whatever its deadline, or fails on demand
+type testCollector struct {
+	name    string
+	Value   string
+	hang    bool
+	fail    bool
+	release chan struct{}
+}
+
+func (c *testCollector) Name() string {
+	return c.name
+}
+
+func (c *testCollector) Configure(options map[string]interface{}) error {
+	return nil
+}
+
+func (c *testCollector) Collect(ctx context.Context) error {
+	if c.hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if c.release != nil {
+		<-c.release
+	}
+	c.Value = "collected"
+	if c.fail {
+		return errors.New("collection failed")
+	}
+	return nil
+}
+
+func init() {
+	collector.Register("test-ok", func() collector.Collector { return &testCollector{name: "test-ok"} })
+	collector.Register("test-hang", func() collector.Collector { return &testCollector{name: "test-hang", hang: true} })
+	collector.Register("test-fail", func() collector.Collector { return &testCollector{name: "test-fail", fail: true} })
+	collector.Register("test-stuck", func() collector.Collector { return &testCollector{name: "test-stuck", release: stuckRelease} })
+}
+
+// stuckRelease releases the runs of the test-stuck collector
+var stuckRelease = make(chan struct{})
+
+// testConfig returns a configuration with only the test collectors enabled
+func testConfig(t *testing.T) config.Config {
+	var conf config.Config
+	err := json.Unmarshal([]byte(`{"settings": {
+		"reporting": {"collector_timeout_seconds": 1},
+		"collectors": {
+			"cpu": {"enabled": false},
+			"cpu_info": {"enabled": false},
+			"disks": {"enabled": false},
+			"host_info": {"enabled": false},
+			"memory": {"enabled": false},
+			"network": {"enabled": false},
+			"system": {"enabled": false},
+			"test-stuck": {"enabled": false}
+		}
+	}}`), &conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+func TestSnapshot_Collector(t *testing.T) {
+	conf := testConfig(t)
+	config.Store(&conf)
+	defer config.Store(&config.Config{})
+
+	var snapshot Snapshot
+	snapshot.Collector(context.Background())
+
+	tests := []struct {
+		name     string
+		value    string
+		err      string
+		timedOut bool
+	}{
+		{"test-fail", "collected", "collection failed", false},
+		{"test-hang", "", "context deadline exceeded", true},
+		{"test-ok", "collected", "", false},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			var value string
+			if c, ok := snapshot.Custom[test.name]; ok {
+				value = c.(*testCollector).Value
+			}
+			if value != test.value {
+				t.Fatalf("expected '%s', got '%s'", test.value, value)
+			}
+
+			var actual CollectorError
+			for _, collectorError := range snapshot.Errors {
+				if collectorError.Collector == test.name {
+					actual = collectorError
+				}
+			}
+			if actual.Error != test.err || actual.TimedOut != test.timedOut {
+				t.Fatalf("expected '%s' (timed out %t), got '%s' (timed out %t)", test.err, test.timedOut, actual.Error, actual.TimedOut)
+			}
+		})
+	}
+
+	if snapshot.CPU != nil || snapshot.Time.IsZero() {
+		t.Fatal("expected only the test collectors in the snapshot")
+	}
+}
+
+func TestSnapshot_Merge(t *testing.T) {
+	cpu := &collector.CPU{Count: 1}
+	system := &collector.System{HostInfo: &collector.HostInfoStat{Uptime: 200, Procs: 20}}
+	snapshot := Snapshot{
+		CPU:            cpu,
+		System:         system,
+		CPUInfo:        &collector.CPUInfo{Info: []collector.CPUInfoStat{{ModelName: "Xeon"}}},
+		HostInfo:       &collector.HostInfo{Info: &collector.HostInfoStat{Hostname: "web-01", Uptime: 100, Procs: 10}},
+		DiskPartitions: &collector.DiskPartitions{Partitions: []collector.DiskPartitionStat{{Mountpoint: "/"}}},
+	}
+	snapshot.merge()
+
+	// the static information is reported within its segment, with the current uptime
+	jsonStr, err := json.Marshal(&snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual struct {
+		CPU struct {
+			Info []collector.CPUInfoStat `json:"cpu_info_stat"`
+		} `json:"cpu"`
+		System struct {
+			HostInfo collector.HostInfoStat `json:"host_info"`
+		} `json:"system"`
+		Disks *collector.Disks `json:"disks"`
+	}
+	if err = json.Unmarshal(jsonStr, &actual); err != nil {
+		t.Fatal(err)
+	}
+	info := actual.System.HostInfo
+	if fmt.Sprint(actual.CPU.Info[0].ModelName, " ", info.Hostname, " ", info.Uptime, " ", info.Procs, " ", actual.Disks) != "Xeon web-01 200 20 <nil>" {
+		t.Fatalf("expected 'Xeon web-01 200 20 <nil>', got '%s'", jsonStr)
+	}
+
+	// the collectors, which may be shared with other snapshots, are left as they are
+	if cpu.Info != nil || system.HostInfo.Hostname != "" {
+		t.Fatal("expected the collectors to be copied")
+	}
+}
+
+func TestScheduler_Snapshot(t *testing.T) {
+	conf := testConfig(t)
+	conf.Settings.Reporting.CollectFrequencySeconds = 4
+	conf.Settings.Collectors["test-hang"] = conf.Settings.Collectors["cpu"]
+	failing := conf.Settings.Collectors["test-fail"]
+	failing.IntervalSeconds = 6
+	conf.Settings.Collectors["test-fail"] = failing
+	config.Store(&conf)
+	defer config.Store(&config.Config{})
+
+	scheduler := NewScheduler()
+	if scheduler.Tick != 2*time.Second {
+		t.Fatalf("expected '2s', got '%s'", scheduler.Tick)
+	}
+	start := time.Now()
+
+	// the results merged from an earlier run carry the time of that run
+	tests := []struct {
+		after    time.Duration
+		expected []string
+		stale    string
+	}{
+		{0, []string{"test-fail", "test-ok"}, "map[]"},
+		{2 * time.Second, nil, ""},
+		{4 * time.Second, []string{"test-ok"}, "map[test-fail:0s]"},
+		{6 * time.Second, []string{"test-fail"}, "map[test-ok:4s]"},
+		{8 * time.Second, []string{"test-ok"}, "map[test-fail:6s]"},
+		{12 * time.Second, []string{"test-fail", "test-ok"}, "map[]"},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			before := make(map[string]collector.Collector)
+			for name, c := range scheduler.latest {
+				before[name] = c
+			}
+
+			snapshot := scheduler.Snapshot(context.Background(), start.Add(test.after))
+			if test.expected == nil {
+				if snapshot != nil {
+					t.Fatal("expected no snapshot")
+				}
+				return
+			}
+
+			var collected []string
+			for _, name := range []string{"test-fail", "test-ok"} {
+				if scheduler.latest[name] != before[name] {
+					collected = append(collected, name)
+				}
+				if _, merged := snapshot.Custom[name]; !merged {
+					t.Fatalf("expected '%s' to be merged into the snapshot", name)
+				}
+			}
+			if fmt.Sprint(collected) != fmt.Sprint(test.expected) {
+				t.Fatalf("expected '%v', got '%v'", test.expected, collected)
+			}
+
+			stale := make(map[string]time.Duration)
+			for name, at := range snapshot.CollectedAt {
+				stale[name] = at.Sub(start)
+			}
+			if fmt.Sprint(stale) != test.stale {
+				t.Fatalf("expected '%s', got '%v'", test.stale, stale)
+			}
+		})
+	}
+}
+
+func TestCollect_StillRunning(t *testing.T) {
+	conf := testConfig(t)
+	config.Store(&conf)
+	defer config.Store(&config.Config{})
+
+	// a run abandoned at its deadline keeps the collector from running again until it returns
+	tests := []struct {
+		release bool
+		err     string
+	}{
+		{false, "context deadline exceeded"},
+		{false, "the previous run is still in progress"},
+		{true, "<nil>"},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			if test.release {
+				close(stuckRelease)
+				for !startRun("test-stuck") {
+					time.Sleep(time.Millisecond)
+				}
+				endRun("test-stuck")
+			}
+
+			if result := collect(context.Background(), "test-stuck"); fmt.Sprint(result.err) != test.err {
+				t.Fatalf("expected '%s', got '%v'", test.err, result.err)
+			}
+		})
+	}
+}
+
+func TestScheduler_SnapshotTimedOut(t *testing.T) {
+	conf := testConfig(t)
+	conf.Settings.Collectors["test-fail"] = conf.Settings.Collectors["cpu"]
+	config.Store(&conf)
+	defer config.Store(&config.Config{})
+
+	// the result of a collector which timed out is left out, rather than the one of its earlier run
+	scheduler := NewScheduler()
+	scheduler.latest["test-hang"] = &testCollector{name: "test-hang", Value: "earlier"}
+	scheduler.collected["test-hang"] = time.Now().Add(-time.Hour)
+
+	snapshot := scheduler.Snapshot(context.Background(), time.Now())
+	if _, merged := snapshot.Custom["test-hang"]; merged {
+		t.Fatal("expected 'test-hang' to be left out")
+	}
+	if _, merged := snapshot.Custom["test-ok"]; !merged {
+		t.Fatal("expected 'test-ok' to be merged")
+	}
+}
+
+func TestSnapshot_FreshMetrics(t *testing.T) {
+	// the snapshot has 2 metrics of its test collector, and 11 derived from the CPU
+	tests := []struct {
+		collectedAt map[string]time.Time
+		fresh       int
+	}{
+		{nil, 13},
+		{map[string]time.Time{"test-metrics": time.Now()}, 11},
+		{map[string]time.Time{"cpu": time.Now()}, 2},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			snapshot := &Snapshot{
+				Custom: map[string]collector.Collector{"test-metrics": &metricsCollector{[]collector.Metric{
+					{Measurement: "mem", Field: "used_percent", Value: 1},
+					{Measurement: "mem", Field: "free", Value: 2},
+				}}},
+				Derived:     &Derived{CPU: []CPUUtilization{{CPU: "cpu-total", Busy: 10}}},
+				CollectedAt: test.collectedAt,
+			}
+
+			if metrics := len(snapshot.Metrics()); metrics != 13 {
+				t.Fatalf("expected '13', got '%d'", metrics)
+			}
+			if fresh := len(snapshot.FreshMetrics()); fresh != test.fresh {
+				t.Fatalf("expected '%d', got '%d'", test.fresh, fresh)
+			}
+		})
+	}
+}
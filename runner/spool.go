@@ -0,0 +1,198 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	error2 "github.com/jsanc623/ServerStatusEmitter/sphlog"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	segmentPrefix = "segment-"
+	segmentSuffix = ".json"
+
+	// quarantinePrefix names the segments which could not be decoded,
+	// kept aside for inspection rather than replayed
+	quarantinePrefix = "quarantined-"
+)
+
+// Spool keeps Cache batches which could not be reported to the
+// mothership on disk, one segment file per batch. Segment names
+// sort in the order they were written, so they can be replayed
+// in order once the mothership is reachable again.
+type Spool struct {
+	Directory string
+	MaxBytes  int64
+	MaxAge    time.Duration
+
+	mutex    sync.Mutex
+	sequence uint64
+}
+
+// NewSpool creates the spool directory if needed and returns a Spool
+// which caps its segments to maxBytes and maxAge. A zero cap is unlimited.
+func NewSpool(directory string, maxBytes int64, maxAge time.Duration) (*Spool, error) {
+	if directory == "" {
+		return nil, errors.New("spool directory is not set")
+	}
+
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, err
+	}
+
+	return &Spool{
+		Directory: directory,
+		MaxBytes:  maxBytes,
+		MaxAge:    maxAge,
+	}, nil
+}
+
+// Store writes the Cache to a new segment file, then drops segments
+// which exceed the age and size caps.
+func (Spool *Spool) Store(cache *Cache) error {
+	jsonStr, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+
+	Spool.mutex.Lock()
+	defer Spool.mutex.Unlock()
+
+	Spool.sequence++
+	name := fmt.Sprintf("%s%020d-%06d%s", segmentPrefix, time.Now().UTC().UnixNano(), Spool.sequence%1000000, segmentSuffix)
+
+	// Write to a temporary file first so a crash never leaves a partial segment behind
+	tmp := filepath.Join(Spool.Directory, "."+name)
+	if err = ioutil.WriteFile(tmp, jsonStr, 0600); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp, filepath.Join(Spool.Directory, name)); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	error2.LogWarn(fmt.Sprintf("spooled %d snapshots to %s", len(cache.Node), name))
+	return Spool.enforce()
+}
+
+// ReplayTo calls check, if any, once there are segments to replay, then
+// hands every spooled Cache to deliver, oldest first, removing each one
+// once it is delivered. It stops at the first delivery failure so that
+// the remaining segments keep their order. A segment which cannot be
+// decoded is quarantined instead, as it would never be delivered.
+func (Spool *Spool) ReplayTo(check func() error, deliver func(cache *Cache) error) error {
+	Spool.mutex.Lock()
+	defer Spool.mutex.Unlock()
+
+	if err := Spool.enforce(); err != nil {
+		return err
+	}
+
+	segments, err := Spool.segments()
+	if err != nil || len(segments) == 0 {
+		return err
+	}
+
+	if check != nil {
+		if err = check(); err != nil {
+			return err
+		}
+	}
+
+	for index, segment := range segments {
+		segmentPath := filepath.Join(Spool.Directory, segment.Name())
+
+		jsonStr, err := ioutil.ReadFile(segmentPath)
+		if err != nil {
+			return err
+		}
+
+		var cache Cache
+		if err = json.Unmarshal(jsonStr, &cache); err != nil {
+			if err = Spool.quarantine(segment, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = deliver(&cache); err != nil {
+			return fmt.Errorf("spool replay stopped with %d segments remaining: %s", len(segments)-index, err)
+		}
+
+		if err = os.Remove(segmentPath); err != nil {
+			return err
+		}
+	}
+
+	error2.LogInfo(fmt.Sprintf("replayed %d spooled segments from %s", len(segments), Spool.Directory))
+	return nil
+}
+
+// segments returns the segment files in the spool directory, oldest first
+func (Spool *Spool) segments() ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(Spool.Directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []os.FileInfo
+	for _, file := range files {
+		if !file.IsDir() && strings.HasPrefix(file.Name(), segmentPrefix) && strings.HasSuffix(file.Name(), segmentSuffix) {
+			segments = append(segments, file)
+		}
+	}
+
+	return segments, nil
+}
+
+// enforce drops segments older than MaxAge, then the oldest
+// segments until the spool fits in MaxBytes.
+func (Spool *Spool) enforce() error {
+	segments, err := Spool.segments()
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	var kept []os.FileInfo
+	for _, segment := range segments {
+		if Spool.MaxAge > 0 && time.Since(segment.ModTime()) > Spool.MaxAge {
+			if err = Spool.drop(segment, "expired"); err != nil {
+				return err
+			}
+			continue
+		}
+		size += segment.Size()
+		kept = append(kept, segment)
+	}
+
+	for len(kept) > 0 && Spool.MaxBytes > 0 && size > Spool.MaxBytes {
+		if err = Spool.drop(kept[0], "over size cap"); err != nil {
+			return err
+		}
+		size -= kept[0].Size()
+		kept = kept[1:]
+	}
+
+	return nil
+}
+
+// quarantine moves a segment which cannot be decoded out of the replay,
+// next to the segments, and logs why
+func (Spool *Spool) quarantine(segment os.FileInfo, reason error) error {
+	name := quarantinePrefix + segment.Name()
+	error2.LogError(fmt.Errorf("quarantining spooled segment %s as %s: %s", segment.Name(), name, reason))
+	return os.Rename(filepath.Join(Spool.Directory, segment.Name()), filepath.Join(Spool.Directory, name))
+}
+
+// drop removes a segment and logs why its data was lost
+func (Spool *Spool) drop(segment os.FileInfo, reason string) error {
+	error2.LogWarn(fmt.Sprintf("dropping spooled segment %s: %s", segment.Name(), reason))
+	return os.Remove(filepath.Join(Spool.Directory, segment.Name()))
+}
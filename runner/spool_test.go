@@ -0,0 +1,213 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/config"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSpool(t *testing.T, maxBytes int64, maxAge time.Duration) *Spool {
+	directory, err := ioutil.TempDir("", "sse-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spool, err := NewSpool(directory, maxBytes, maxAge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spool
+}
+
+func TestSpool_Store(t *testing.T) {
+	tests := []struct {
+		maxBytes int64
+		maxAge   time.Duration
+		stored   int
+		expected int
+	}{
+		{0, 0, 3, 3},
+		{1, 0, 3, 0},
+		{0, time.Nanosecond, 3, 0},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			spool := newTestSpool(t, test.maxBytes, test.maxAge)
+			defer func() {
+				_ = os.RemoveAll(spool.Directory)
+			}()
+
+			for n := 0; n < test.stored; n++ {
+				if err := spool.Store(&Cache{ID: fmt.Sprintf("%d", n)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			time.Sleep(time.Millisecond)
+
+			if err := spool.enforce(); err != nil {
+				t.Fatal(err)
+			}
+			if actual := pending(t, spool); actual != test.expected {
+				t.Fatalf("expected '%d', got '%d'", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSpool_ReplayTo(t *testing.T) {
+	spool := newTestSpool(t, 0, 0)
+	defer func() {
+		_ = os.RemoveAll(spool.Directory)
+	}()
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := spool.Store(&Cache{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the segments are replayed in order once the destination is up, and
+	// kept in order as long as one of them is not delivered
+	down := errors.New("down")
+	var received []string
+	tests := []struct {
+		status   error
+		failing  string
+		pending  int
+		received string
+	}{
+		{down, "", 3, "[]"},
+		{nil, "b", 2, "[a]"},
+		{nil, "", 0, "[a b c]"},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			err := spool.ReplayTo(func() error {
+				return test.status
+			}, func(cache *Cache) error {
+				if cache.ID == test.failing {
+					return down
+				}
+				received = append(received, cache.ID)
+				return nil
+			})
+			if (err != nil) != (test.status != nil || test.failing != "") {
+				t.Fatalf("expected the replay to fail '%t', got '%v'", test.status != nil || test.failing != "", err)
+			}
+			if actual := pending(t, spool); actual != test.pending {
+				t.Fatalf("expected '%d', got '%d'", test.pending, actual)
+			}
+			if fmt.Sprint(received) != test.received {
+				t.Fatalf("expected '%s', got '%v'", test.received, received)
+			}
+		})
+	}
+}
+
+func TestSpool_ReplayTo_Quarantine(t *testing.T) {
+	spool := newTestSpool(t, 0, 0)
+	defer func() {
+		_ = os.RemoveAll(spool.Directory)
+	}()
+
+	// a truncated segment, older than the others
+	corrupt := segmentPrefix + "00000000000000000000-000000" + segmentSuffix
+	if err := ioutil.WriteFile(filepath.Join(spool.Directory, corrupt), []byte(`{"id": "a`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"b", "c"} {
+		if err := spool.Store(&Cache{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var received []string
+	err := spool.ReplayTo(nil, func(cache *Cache) error {
+		received = append(received, cache.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(received) != "[b c]" {
+		t.Fatalf("expected '[b c]', got '%v'", received)
+	}
+	if actual := pending(t, spool); actual != 0 {
+		t.Fatalf("expected '0', got '%d'", actual)
+	}
+	if _, err = os.Stat(filepath.Join(spool.Directory, quarantinePrefix+corrupt)); err != nil {
+		t.Fatalf("expected the segment to be quarantined, got '%s'", err)
+	}
+}
+
+func TestOutput_Flush(t *testing.T) {
+	var statuses int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/status") {
+			statuses++
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	conf := testConfig(t)
+	conf.Mothership = server.URL
+	config.Store(&conf)
+	defer config.Store(&config.Config{})
+
+	spool := newTestSpool(t, 0, 0)
+	defer func() {
+		_ = os.RemoveAll(spool.Directory)
+	}()
+
+	// an expired deadline gives up on the mothership right away
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// the status of the mothership is only checked once there is a spool to replay
+	tests := []struct {
+		spool    *Spool
+		lost     bool
+		pending  int
+		statuses int
+	}{
+		{nil, true, 0, 0},
+		{spool, false, 1, 0},
+		{spool, false, 2, 1},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			output := &Output{Sink: &MothershipSink{}, policy: newRetryPolicy(), spool: test.spool}
+			err := output.flush(ctx, &Cache{Node: []*Snapshot{{}}})
+			if (err != nil) != test.lost {
+				t.Fatalf("expected lost '%t', got '%v'", test.lost, err)
+			}
+			if test.spool != nil && pending(t, test.spool) != test.pending {
+				t.Fatalf("expected '%d', got '%d'", test.pending, pending(t, test.spool))
+			}
+			if statuses != test.statuses {
+				t.Fatalf("expected '%d', got '%d'", test.statuses, statuses)
+			}
+		})
+	}
+}
+
+// pending returns the number of segments waiting to be replayed
+func pending(t *testing.T, spool *Spool) int {
+	segments, err := spool.segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(segments)
+}
@@ -0,0 +1,383 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/collector"
+	"github.com/jsanc623/ServerStatusEmitter/config"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Transformer turns a Cache into the document sent by the JSON sinks
+type Transformer func(cache *Cache) interface{}
+
+// defaultFormat is the format used when none is configured
+const defaultFormat = "raw"
+
+var (
+	transformerMutex    sync.RWMutex
+	transformerRegistry = make(map[string]Transformer)
+)
+
+func init() {
+	RegisterTransformer("raw", func(cache *Cache) interface{} { return Raw(cache) })
+	RegisterTransformer("saveable", func(cache *Cache) interface{} { return Saveable(cache) })
+
+	// Validate the reporting format of the configuration against the registered transformers
+	config.KnownFormats = Formats
+}
+
+// RegisterTransformer makes a transformer available under the given format.
+// It panics if the format is registered twice.
+func RegisterTransformer(format string, transformer Transformer) {
+	transformerMutex.Lock()
+	defer transformerMutex.Unlock()
+
+	if _, exists := transformerRegistry[format]; exists {
+		panic("runner: RegisterTransformer called twice for " + format)
+	}
+	transformerRegistry[format] = transformer
+}
+
+// Formats returns the sorted formats of all registered transformers
+func Formats() []string {
+	transformerMutex.RLock()
+	defer transformerMutex.RUnlock()
+
+	formats := make([]string, 0, len(transformerRegistry))
+	for format := range transformerRegistry {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// Encode returns the JSON document of the Cache in the configured reporting format
+func (Cache *Cache) Encode() ([]byte, error) {
+	format := config.Current().Settings.Reporting.Format
+	if format == "" {
+		format = defaultFormat
+	}
+
+	transformerMutex.RLock()
+	transformer, exists := transformerRegistry[format]
+	transformerMutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("unknown reporting format %q", format)
+	}
+	return json.Marshal(transformer(Cache))
+}
+
+// RawCache is a Cache with its snapshots as they are collected, under the
+// keys documented in samples/sample_raw.json
+type RawCache struct {
+	Node         []*Snapshot `json:"node"`
+	Server       *Server     `json:"server"`
+	ID           string      `json:"account_id"`
+	Version      string      `json:"version"`
+	Organization string      `json:"organization_name"`
+	Group        string      `json:"group,omitempty"`
+	Entity       string      `json:"machine_nickname"`
+}
+
+// Raw returns the Cache in its raw form
+func Raw(cache *Cache) *RawCache {
+	return &RawCache{
+		Node:         cache.Node,
+		Server:       cache.Server,
+		ID:           cache.ID,
+		Version:      cache.Version,
+		Organization: cache.Organization,
+		Group:        cache.Group,
+		Entity:       cache.Entity,
+	}
+}
+
+// SaveableCache is the compact form of a Cache the mothership stores as it
+// is: the times and the information of every CPU are merged, and the static
+// information is stripped of its whitespace.
+type SaveableCache struct {
+	Node         []*SaveableSnapshot `json:"node"`
+	Server       *Server             `json:"server"`
+	ID           string              `json:"account_id"`
+	Organization string              `json:"organization_name"`
+	Group        string              `json:"group,omitempty"`
+	Entity       string              `json:"machine_nickname"`
+}
+
+// SaveableSnapshot is a Snapshot as documented in samples/sample_saveable.json:
+// its CPU is merged, and its disks, memory and system hold the documented fields
+type SaveableSnapshot struct {
+	SchemaVersion int                            `json:"schema_version"`
+	CPU           *SaveableCPU                   `json:"cpu"`
+	Disks         *SaveableDisks                 `json:"disks"`
+	Memory        *SaveableMemory                `json:"memory"`
+	Network       *collector.Network             `json:"network"`
+	System        *SaveableSystem                `json:"system"`
+	Processes     *collector.Processes           `json:"processes,omitempty"`
+	Watch         *collector.Watch               `json:"watch,omitempty"`
+	Custom        map[string]collector.Collector `json:"custom,omitempty"`
+	Derived       *Derived                       `json:"derived,omitempty"`
+	CollectedAt   map[string]time.Time           `json:"collected_at,omitempty"`
+	Errors        []CollectorError               `json:"errors,omitempty"`
+	Time          time.Time                      `json:"system_time"`
+}
+
+// SaveableDisks are the Disks without the IO operations in progress
+type SaveableDisks struct {
+	DiskUsage      *collector.DiskUsageStat              `json:"disk_usage_stat"`
+	DiskPartition  []collector.DiskPartitionStat         `json:"disk_partition_stat"`
+	DiskIOCounters map[string]SaveableDiskIOCountersStat `json:"disk_io_counters_stat"`
+}
+
+// SaveableDiskIOCountersStat are the IO counters of a disk, without the IO operations in progress
+type SaveableDiskIOCountersStat struct {
+	ReadCount    uint64 `json:"read_count"`
+	WriteCount   uint64 `json:"write_count"`
+	ReadBytes    uint64 `json:"read_bytes"`
+	WriteBytes   uint64 `json:"write_bytes"`
+	ReadTime     uint64 `json:"read_time"`
+	WriteTime    uint64 `json:"write_time"`
+	Name         string `json:"name"`
+	IoTime       uint64 `json:"io_time"`
+	SerialNumber string `json:"serial_number"`
+}
+
+// SaveableMemory is the Memory without the used percents, which follow from
+// the totals, and the platform specific wired and shared memory
+type SaveableMemory struct {
+	VirtualMemoryStat *SaveableVirtualMemoryStat `json:"virtual_memory_stat"`
+	SwapMemoryStat    *SaveableSwapMemoryStat    `json:"swap_memory_stat"`
+}
+
+// SaveableVirtualMemoryStat is the usage of the RAM, in bytes
+type SaveableVirtualMemoryStat struct {
+	Total     uint64 `json:"total"`
+	Available uint64 `json:"available"`
+	Used      uint64 `json:"used"`
+	Free      uint64 `json:"free"`
+	Active    uint64 `json:"active"`
+	Inactive  uint64 `json:"inactive"`
+	Buffers   uint64 `json:"buffers"`
+	Cached    uint64 `json:"cached"`
+}
+
+// SaveableSwapMemoryStat is the usage of the swap, in bytes
+type SaveableSwapMemoryStat struct {
+	Total uint64 `json:"total"`
+	Used  uint64 `json:"used"`
+	Free  uint64 `json:"free"`
+	Sin   uint64 `json:"sin"`
+	Sout  uint64 `json:"sout"`
+}
+
+// SaveableSystem is the System, with the users only when they are collected
+type SaveableSystem struct {
+	HostInfo *collector.HostInfoStat `json:"host_info"`
+	LoadAvg  *collector.LoadAvgStat  `json:"load_avg"`
+	Users    []collector.UserStat    `json:"users,omitempty"`
+}
+
+// SaveableCPU holds a single list of stats per CPU
+type SaveableCPU struct {
+	Stats        []SaveableCPUStat `json:"stats"`
+	Count        int               `json:"cpu_count"`
+	CountLogical int               `json:"cpu_count_logical"`
+}
+
+// SaveableCPUStat is the times of a CPU followed by its information, without its flags
+type SaveableCPUStat struct {
+	CPU       int32   `json:"cpu"`
+	User      float64 `json:"user"`
+	System    float64 `json:"system"`
+	Idle      float64 `json:"idle"`
+	Nice      float64 `json:"nice"`
+	Iowait    float64 `json:"iowait"`
+	Irq       float64 `json:"irq"`
+	Softirq   float64 `json:"softirq"`
+	Steal     float64 `json:"steal"`
+	Guest     float64 `json:"guest"`
+	GuestNice float64 `json:"guest_nice"`
+	Stolen    float64 `json:"stolen"`
+
+	VendorID   string  `json:"vendor_id"`
+	Family     string  `json:"family"`
+	Model      string  `json:"model"`
+	Stepping   int32   `json:"stepping"`
+	PhysicalID string  `json:"physical_id"`
+	CoreID     string  `json:"core_id"`
+	Cores      int32   `json:"cores"`
+	ModelName  string  `json:"model_name"`
+	Mhz        float64 `json:"mhz"`
+	CacheSize  int32   `json:"cache_size"`
+}
+
+// Saveable returns the Cache in its saveable form. The emitter version is
+// left out, the mothership records it when the emitter registers.
+func Saveable(cache *Cache) *SaveableCache {
+	saveable := &SaveableCache{
+		Node:         make([]*SaveableSnapshot, 0, len(cache.Node)),
+		ID:           cache.ID,
+		Organization: stripSpaces(cache.Organization),
+		Group:        cache.Group,
+		Entity:       cache.Entity,
+	}
+
+	if cache.Server != nil {
+		server := *cache.Server
+		server.OperatingSystem.Distributor = stripSpaces(server.OperatingSystem.Distributor)
+		server.OperatingSystem.VersionSignature = stripSpaces(server.OperatingSystem.VersionSignature)
+		server.OperatingSystem.Version = stripSpaces(server.OperatingSystem.Version)
+		saveable.Server = &server
+	}
+
+	for _, snapshot := range cache.Node {
+		saveable.Node = append(saveable.Node, &SaveableSnapshot{
+			SchemaVersion: snapshot.SchemaVersion,
+			CPU:           saveableCPU(snapshot.CPU),
+			Disks:         saveableDisks(snapshot.Disks),
+			Memory:        saveableMemory(snapshot.Memory),
+			Network:       snapshot.Network,
+			System:        saveableSystem(snapshot.System),
+			Processes:     snapshot.Processes,
+			Watch:         snapshot.Watch,
+			Custom:        snapshot.Custom,
+			Derived:       snapshot.Derived,
+			CollectedAt:   snapshot.CollectedAt,
+			Errors:        snapshot.Errors,
+			Time:          snapshot.Time,
+		})
+	}
+
+	return saveable
+}
+
+// saveableCPU merges the times of every CPU with its information, paired by
+// position. A CPU without information is numbered after its position.
+func saveableCPU(cpu *collector.CPU) *SaveableCPU {
+	if cpu == nil {
+		return nil
+	}
+
+	saveable := &SaveableCPU{
+		Stats:        make([]SaveableCPUStat, 0, len(cpu.Times)),
+		Count:        cpu.Count,
+		CountLogical: cpu.CountLogical,
+	}
+
+	for index, times := range cpu.Times {
+		stat := SaveableCPUStat{
+			CPU:       int32(index),
+			User:      times.User,
+			System:    times.System,
+			Idle:      times.Idle,
+			Nice:      times.Nice,
+			Iowait:    times.Iowait,
+			Irq:       times.Irq,
+			Softirq:   times.Softirq,
+			Steal:     times.Steal,
+			Guest:     times.Guest,
+			GuestNice: times.GuestNice,
+			Stolen:    times.Stolen,
+		}
+
+		if index < len(cpu.Info) {
+			info := cpu.Info[index]
+			stat.CPU = info.CPU
+			stat.VendorID = stripSpaces(info.VendorID)
+			stat.Family = stripSpaces(info.Family)
+			stat.Model = stripSpaces(info.Model)
+			stat.Stepping = info.Stepping
+			stat.PhysicalID = stripSpaces(info.PhysicalID)
+			stat.CoreID = stripSpaces(info.CoreID)
+			stat.Cores = info.Cores
+			stat.ModelName = stripSpaces(info.ModelName)
+			stat.Mhz = info.Mhz
+			stat.CacheSize = info.CacheSize
+		}
+
+		saveable.Stats = append(saveable.Stats, stat)
+	}
+
+	return saveable
+}
+
+// saveableDisks leaves the IO operations in progress out of the Disks
+func saveableDisks(disks *collector.Disks) *SaveableDisks {
+	if disks == nil {
+		return nil
+	}
+
+	saveable := &SaveableDisks{DiskUsage: disks.DiskUsage, DiskPartition: disks.DiskPartition}
+	if disks.DiskIOCounters != nil {
+		saveable.DiskIOCounters = make(map[string]SaveableDiskIOCountersStat, len(disks.DiskIOCounters))
+		for name, io := range disks.DiskIOCounters {
+			saveable.DiskIOCounters[name] = SaveableDiskIOCountersStat{
+				ReadCount:    io.ReadCount,
+				WriteCount:   io.WriteCount,
+				ReadBytes:    io.ReadBytes,
+				WriteBytes:   io.WriteBytes,
+				ReadTime:     io.ReadTime,
+				WriteTime:    io.WriteTime,
+				Name:         io.Name,
+				IoTime:       io.IoTime,
+				SerialNumber: io.SerialNumber,
+			}
+		}
+	}
+	return saveable
+}
+
+// saveableMemory leaves the used percents, the wired and the shared memory out of the Memory
+func saveableMemory(memory *collector.Memory) *SaveableMemory {
+	if memory == nil {
+		return nil
+	}
+
+	saveable := &SaveableMemory{}
+	if virtual := memory.VirtualMemoryStat; virtual != nil {
+		saveable.VirtualMemoryStat = &SaveableVirtualMemoryStat{
+			Total:     virtual.Total,
+			Available: virtual.Available,
+			Used:      virtual.Used,
+			Free:      virtual.Free,
+			Active:    virtual.Active,
+			Inactive:  virtual.Inactive,
+			Buffers:   virtual.Buffers,
+			Cached:    virtual.Cached,
+		}
+	}
+	if swap := memory.SwapMemoryStat; swap != nil {
+		saveable.SwapMemoryStat = &SaveableSwapMemoryStat{
+			Total: swap.Total,
+			Used:  swap.Used,
+			Free:  swap.Free,
+			Sin:   swap.Sin,
+			Sout:  swap.Sout,
+		}
+	}
+	return saveable
+}
+
+// saveableSystem returns the System with its users only when they were collected
+func saveableSystem(system *collector.System) *SaveableSystem {
+	if system == nil {
+		return nil
+	}
+	return &SaveableSystem{HostInfo: system.HostInfo, LoadAvg: system.LoadAvg, Users: system.Users}
+}
+
+// stripSpaces removes every whitespace character from s
+func stripSpaces(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,236 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jsanc623/ServerStatusEmitter/config"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// deviation is a deliberate change of the payload from its documented sample
+type deviation struct {
+	reason string
+	apply  func(document map[string]interface{})
+}
+
+// versioned, keyless, stringLoadAvg, stringAddrs, iopsInProgress and
+// unspacedColons are the deviations of the payloads from samples/*.json
+var (
+	versioned = deviation{"the snapshots carry their schema version", func(document map[string]interface{}) {
+		for _, node := range nodes(document) {
+			node["schema_version"] = float64(SchemaVersion)
+		}
+	}}
+	keyless = deviation{"the identification key signs the requests instead of being sent", func(document map[string]interface{}) {
+		delete(document, "organization_id")
+	}}
+	stringLoadAvg = deviation{"the load averages are strings, as in samples/sample_saveable.json", func(document map[string]interface{}) {
+		for _, node := range nodes(document) {
+			system := node["system"].(map[string]interface{})
+			avg := system["load_avg"].(map[string]interface{})
+			system["load_avg"] = []interface{}{
+				strconv.FormatFloat(avg["load1"].(float64), 'f', -1, 64),
+				strconv.FormatFloat(avg["load5"].(float64), 'f', -1, 64),
+				strconv.FormatFloat(avg["load15"].(float64), 'f', -1, 64),
+			}
+		}
+	}}
+	stringAddrs = deviation{"the interface addresses are strings, as in samples/sample_saveable.json", func(document map[string]interface{}) {
+		for _, node := range nodes(document) {
+			for _, netInterface := range node["network"].(map[string]interface{})["interface"].([]interface{}) {
+				addrs := netInterface.(map[string]interface{})["addrs"].([]interface{})
+				for index, addr := range addrs {
+					addrs[index] = addr.(map[string]interface{})["addr"]
+				}
+			}
+		}
+	}}
+	iopsInProgress = deviation{"the disk IO counters hold the IO operations in progress gopsutil reports", func(document map[string]interface{}) {
+		for _, node := range nodes(document) {
+			for _, io := range node["disks"].(map[string]interface{})["disk_io_counters_stat"].(map[string]interface{}) {
+				io.(map[string]interface{})["iops_in_progress"] = float64(0)
+			}
+		}
+	}}
+	unspacedColons = deviation{"the values of the sample have a space after every ':', the emitter sends them as collected", func(document map[string]interface{}) {
+		unspaceColons(document)
+	}}
+)
+
+// nodes returns the snapshots of a decoded payload
+func nodes(document map[string]interface{}) []map[string]interface{} {
+	var snapshots []map[string]interface{}
+	for _, node := range document["node"].([]interface{}) {
+		snapshots = append(snapshots, node.(map[string]interface{}))
+	}
+	return snapshots
+}
+
+// unspaceColons removes the space after every ':' in the strings of a decoded document
+func unspaceColons(value interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		return strings.Replace(value, ": ", ":", -1)
+	case []interface{}:
+		for index, item := range value {
+			value[index] = unspaceColons(item)
+		}
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = unspaceColons(item)
+		}
+	}
+	return value
+}
+
+// sample returns the payload of a sample file with the deviations applied
+func sample(t *testing.T, name string, deviations []deviation) map[string]interface{} {
+	jsonStr, err := ioutil.ReadFile("../samples/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var document map[string]interface{}
+	if err = json.Unmarshal(jsonStr, &document); err != nil {
+		t.Fatal(err)
+	}
+	for _, deviation := range deviations {
+		deviation.apply(document)
+	}
+	return document
+}
+
+// The deviations of the raw and saveable payloads from their samples
+var (
+	rawDeviations      = []deviation{versioned, keyless, stringLoadAvg, stringAddrs, iopsInProgress}
+	saveableDeviations = []deviation{versioned, keyless, unspacedColons}
+)
+
+// sampleCache returns the Cache of samples/sample_raw.json
+func sampleCache(t *testing.T) *Cache {
+	jsonStr, err := json.Marshal(sample(t, "sample_raw.json", rawDeviations))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw RawCache
+	if err = json.Unmarshal(jsonStr, &raw); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Cache{
+		Node:         raw.Node,
+		Server:       raw.Server,
+		ID:           raw.ID,
+		Version:      raw.Version,
+		Organization: raw.Organization,
+		Group:        raw.Group,
+		Entity:       raw.Entity,
+	}
+}
+
+// encode returns the sample Cache encoded in format, decoded
+func encode(t *testing.T, format string) (map[string]interface{}, error) {
+	conf := testConfig(t)
+	conf.Settings.Reporting.Format = format
+	config.Store(&conf)
+
+	jsonStr, err := sampleCache(t).Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	var document map[string]interface{}
+	if err = json.Unmarshal(jsonStr, &document); err != nil {
+		t.Fatal(err)
+	}
+	return document, nil
+}
+
+func TestCache_Encode(t *testing.T) {
+	defer config.Store(&config.Config{})
+
+	tests := []struct {
+		format     string
+		sample     string
+		deviations []deviation
+		err        string
+	}{
+		{"", "sample_raw.json", rawDeviations, "<nil>"},
+		{"raw", "sample_raw.json", rawDeviations, "<nil>"},
+		{"saveable", "sample_saveable.json", saveableDeviations, "<nil>"},
+		{"compact", "", nil, `unknown reporting format "compact"`},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			actual, err := encode(t, test.format)
+			if fmt.Sprint(err) != test.err {
+				t.Fatalf("expected '%s', got '%v'", test.err, err)
+			}
+			if err != nil {
+				return
+			}
+
+			// Documents are compared decoded, so the order of their keys does not matter
+			if expected := sample(t, test.sample, test.deviations); !reflect.DeepEqual(actual, expected) {
+				t.Fatalf("expected '%v', got '%v'", expected, actual)
+			}
+		})
+	}
+}
+
+func TestCache_EncodeDeviations(t *testing.T) {
+	defer config.Store(&config.Config{})
+
+	// Every deviation from a sample is needed, the payload no longer matches the sample without it
+	tests := []struct {
+		format     string
+		sample     string
+		deviations []deviation
+	}{
+		{"raw", "sample_raw.json", rawDeviations},
+		{"saveable", "sample_saveable.json", saveableDeviations},
+	}
+
+	for i, test := range tests {
+		actual, err := encode(t, test.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for skipped := range test.deviations {
+			t.Run(fmt.Sprintf("%d.%d", i, skipped), func(t *testing.T) {
+				var deviations []deviation
+				deviations = append(deviations, test.deviations[:skipped]...)
+				deviations = append(deviations, test.deviations[skipped+1:]...)
+				if reflect.DeepEqual(actual, sample(t, test.sample, deviations)) {
+					t.Fatalf("expected the %s payload to need the deviation '%s'", test.format, test.deviations[skipped].reason)
+				}
+			})
+		}
+	}
+}
+
+func TestStripSpaces(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"Intel(R) Xeon(R) CPU E5-2630L v2 @ 2.40GHz", "Intel(R)Xeon(R)CPUE5-2630Lv2@2.40GHz"},
+		{" Ubuntu 14.04.2 LTS \\n\t", "Ubuntu14.04.2LTS\\n"},
+		{"", ""},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			if actual := stripSpaces(test.value); actual != test.expected {
+				t.Fatalf("expected '%s', got '%s'", test.expected, actual)
+			}
+		})
+	}
+}